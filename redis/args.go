@@ -0,0 +1,152 @@
+// Copyright (c) 2015 Datacratic. All rights reserved.
+
+package redis
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var durationType = reflect.TypeOf(time.Duration(0))
+var timeType = reflect.TypeOf(time.Time{})
+var marshalerType = reflect.TypeOf((*Marshaler)(nil)).Elem()
+
+// Args builds the list of arguments of a command.
+type Args []interface{}
+
+// Add appends values as individual arguments.
+func (args Args) Add(values ...interface{}) Args {
+	return append(args, values...)
+}
+
+// AddFlat appends the field/value pairs of a struct or map.
+// Struct fields are named after their `redis:"name"` tag where the omitempty option skips zero values.
+// Nested structs, maps and slices are skipped unless the json option encodes them as JSON.
+// Times are formatted with RFC 3339 and durations as text i.e. 1.5s which UnmarshalReply reads back.
+// Nil pointers are skipped and named types such as `type Role string` are written as their underlying kind.
+func (args Args) AddFlat(v interface{}) Args {
+	value := reflect.ValueOf(v)
+	for value.Kind() == reflect.Ptr || value.Kind() == reflect.Interface {
+		if value.IsNil() {
+			return args
+		}
+
+		value = value.Elem()
+	}
+
+	switch value.Kind() {
+	case reflect.Struct:
+		for _, f := range structFields(value.Type()) {
+			field := value.FieldByIndex(f.index)
+			if item, ok := flatValue(field, f.options()); ok {
+				args = append(args, f.name, item)
+			}
+		}
+	case reflect.Map:
+		keys := value.MapKeys()
+		sort.Slice(keys, func(i, j int) bool {
+			return fmt.Sprint(keys[i].Interface()) < fmt.Sprint(keys[j].Interface())
+		})
+
+		for _, k := range keys {
+			if item, ok := flatValue(value.MapIndex(k), ""); ok {
+				args = append(args, scalarValue(k), item)
+			}
+		}
+	default:
+		args = append(args, v)
+	}
+
+	return args
+}
+
+// Flatten returns the key followed by the field/value pairs of a struct or map.
+// It is meant for commands like HSET e.g. client.Do("HSET", Flatten(key, user)...).
+func Flatten(key interface{}, v interface{}) []interface{} {
+	return Args{key}.AddFlat(v)
+}
+
+// options returns the options of the tag that follow the name.
+func (f *structField) options() string {
+	if i := strings.IndexByte(f.tag, ','); i >= 0 {
+		return f.tag[i:] + ","
+	}
+
+	return ""
+}
+
+// flatValue returns the argument used for a field or false when it must be skipped.
+func flatValue(value reflect.Value, options string) (result interface{}, ok bool) {
+	if strings.Contains(options, ",omitempty,") && value.IsZero() {
+		return
+	}
+
+	// nil values would be written as empty text which can't be read back into numbers
+	for value.Kind() == reflect.Ptr || value.Kind() == reflect.Interface {
+		if value.IsNil() {
+			return
+		}
+
+		value = value.Elem()
+	}
+
+	t := value.Type()
+	switch {
+	case t == timeType:
+		return value.Interface().(time.Time).Format(time.RFC3339Nano), true
+	case t == durationType:
+		return value.Interface().(time.Duration).String(), true
+	case t.Implements(marshalerType):
+		return value.Interface(), true
+	}
+
+	switch value.Kind() {
+	case reflect.Slice:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return value.Bytes(), true
+		}
+
+		fallthrough
+	case reflect.Struct, reflect.Map, reflect.Array:
+		if !strings.Contains(options, ",json,") {
+			return
+		}
+
+		data, err := json.Marshal(value.Interface())
+		if err != nil {
+			return
+		}
+
+		return data, true
+	}
+
+	return scalarValue(value), true
+}
+
+// scalarValue converts named types such as `type Role string` to their underlying kind which the Encoder writes as text.
+// They would be encoded as JSON otherwise e.g. with quotes.
+func scalarValue(value reflect.Value) interface{} {
+	if value.Type().PkgPath() == "" {
+		return value.Interface()
+	}
+
+	switch value.Kind() {
+	case reflect.String:
+		return value.String()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return value.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.FormatUint(value.Uint(), 10)
+	case reflect.Float32, reflect.Float64:
+		return value.Float()
+	case reflect.Bool:
+		return value.Bool()
+	}
+
+	return value.Interface()
+}
@@ -0,0 +1,133 @@
+// Copyright (c) 2015 Datacratic. All rights reserved.
+
+package redis
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type testAddress struct {
+	City string
+}
+
+type testProfile struct {
+	Name     string        `redis:"name"`
+	Age      int           `redis:"age,omitempty"`
+	Email    string        `redis:"email,omitempty"`
+	Created  time.Time     `redis:"created"`
+	TTL      time.Duration `redis:"ttl"`
+	Address  testAddress   `redis:"address,json"`
+	Friends  []string      `redis:"friends"`
+	Internal string        `redis:"-"`
+}
+
+func TestFlatten(t *testing.T) {
+	profile := testProfile{
+		Name:    "John",
+		Email:   "john@example.com",
+		Created: time.Date(2015, 1, 2, 3, 4, 5, 0, time.UTC),
+		TTL:     1500 * time.Millisecond,
+		Address: testAddress{City: "Montreal"},
+		Friends: []string{"Jane"},
+	}
+
+	args := Flatten("user:1", &profile)
+	expected := []interface{}{
+		"user:1",
+		"name", "John",
+		"email", "john@example.com",
+		"created", "2015-01-02T03:04:05Z",
+		"ttl", "1.5s",
+		"address", []byte(`{"City":"Montreal"}`),
+	}
+
+	if !reflect.DeepEqual(args, expected) {
+		t.Fatalf("unexpected arguments '%q' instead of '%q'", args, expected)
+	}
+
+	// read it back as HGETALL would return it
+	reply := make([]interface{}, 0, len(args)-1)
+	for _, arg := range args[1:] {
+		switch arg := arg.(type) {
+		case string:
+			reply = append(reply, []byte(arg))
+		case []byte:
+			reply = append(reply, arg)
+		}
+	}
+
+	var result testProfile
+	if err := UnmarshalReply(reply, &result); err != nil {
+		t.Fatal(err)
+	}
+
+	profile.Friends = nil
+	if !reflect.DeepEqual(result, profile) {
+		t.Fatalf("unexpected result '%+v' instead of '%+v'", result, profile)
+	}
+
+	args = Args{"key"}.AddFlat(map[string]int{"b": 2, "a": 1}).Add("extra")
+	if !reflect.DeepEqual([]interface{}(args), []interface{}{"key", "a", 1, "b", 2, "extra"}) {
+		t.Fatal(args)
+	}
+}
+
+type testRole string
+
+type testLevel uint8
+
+type testAccount struct {
+	Role    testRole  `redis:"role"`
+	Level   testLevel `redis:"level"`
+	Limit   *int      `redis:"limit"`
+	Enabled *bool     `redis:"enabled"`
+}
+
+func TestFlattenNamedTypes(t *testing.T) {
+	enabled := true
+	account := testAccount{
+		Role:    "admin",
+		Level:   3,
+		Enabled: &enabled,
+	}
+
+	args := Flatten("account:1", &account)
+	expected := []interface{}{
+		"account:1",
+		"role", "admin",
+		"level", "3",
+		"enabled", true,
+	}
+
+	if !reflect.DeepEqual(args, expected) {
+		t.Fatalf("unexpected arguments '%#v' instead of '%#v'", args, expected)
+	}
+
+	// read it back as HGETALL would return it after encoding
+	var buffer bytes.Buffer
+	if err := NewEncoder(&buffer).Encode("HSET", args...); err != nil {
+		t.Fatal(err)
+	}
+
+	command, err := NewDecoder(&buffer).Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var result testAccount
+	if err := UnmarshalReply(command.([]interface{})[2:], &result); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(result, account) {
+		t.Fatalf("unexpected result '%+v' instead of '%+v'", result, account)
+	}
+
+	keys := Args{}.AddFlat(map[testRole]int{"admin": 1})
+	if !reflect.DeepEqual([]interface{}(keys), []interface{}{"admin", 1}) {
+		t.Fatal(keys)
+	}
+}
@@ -0,0 +1,224 @@
+// Copyright (c) 2015 Datacratic. All rights reserved.
+
+package redis
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultMaximumIdleBlockingConnections defines the default number of dedicated connections kept for blocking commands once done.
+var DefaultMaximumIdleBlockingConnections = 2
+
+// DefaultBlockingTimeoutMargin defines the time given to the Redis instance to reply past the timeout of a blocking command when no read timeout is set.
+var DefaultBlockingTimeoutMargin = time.Second
+
+// blocking returns the timeout of the command if it blocks the connection until some event happens.
+// A timeout of 0 means the command can block forever.
+func (cmd *command) blocking() (timeout time.Duration, ok bool) {
+	info := DefaultCommands.Lookup(cmd.name)
+	if !info.Blocking {
+		return
+	}
+
+	if info.timeout == nil {
+		return 0, true
+	}
+
+	return info.timeout(cmd.args)
+}
+
+// lastSeconds returns the timeout given in seconds as last argument e.g. BLPOP.
+func lastSeconds(args []interface{}) (time.Duration, bool) {
+	if len(args) == 0 {
+		return 0, false
+	}
+
+	return seconds(args[len(args)-1]), true
+}
+
+// firstSeconds returns the timeout given in seconds as first argument e.g. BLMPOP.
+func firstSeconds(args []interface{}) (time.Duration, bool) {
+	if len(args) == 0 {
+		return 0, false
+	}
+
+	return seconds(args[0]), true
+}
+
+// lastMilliseconds returns the timeout given in milliseconds as last argument e.g. WAIT.
+func lastMilliseconds(args []interface{}) (time.Duration, bool) {
+	if len(args) == 0 {
+		return 0, false
+	}
+
+	return milliseconds(args[len(args)-1]), true
+}
+
+// blockOption returns the timeout of the BLOCK option of XREAD and XREADGROUP which don't block without it.
+func blockOption(args []interface{}) (time.Duration, bool) {
+	for i := 0; i+1 < len(args); i++ {
+		switch strings.ToUpper(argText(args[i])) {
+		case "BLOCK":
+			return milliseconds(args[i+1]), true
+		case "STREAMS":
+			return 0, false
+		}
+	}
+
+	return 0, false
+}
+
+func argText(arg interface{}) string {
+	switch value := arg.(type) {
+	case string:
+		return value
+	case []byte:
+		return string(value)
+	}
+
+	return fmt.Sprint(arg)
+}
+
+// seconds parses a timeout given in seconds where unparsable values mean forever.
+func seconds(arg interface{}) time.Duration {
+	value, _ := strconv.ParseFloat(argText(arg), 64)
+	if value <= 0 {
+		return 0
+	}
+
+	return time.Duration(value * float64(time.Second))
+}
+
+// milliseconds parses a timeout given in milliseconds where unparsable values mean forever.
+func milliseconds(arg interface{}) time.Duration {
+	value, _ := strconv.ParseInt(argText(arg), 10, 64)
+	if value <= 0 {
+		return 0
+	}
+
+	return time.Duration(value) * time.Millisecond
+}
+
+// Block marks the request as blocking the connection for up to the specified timeout (0 meaning forever).
+// Blocking commands of Redis are detected automatically so this is only required for others such as the ones of modules.
+func (request *Request) Block(timeout time.Duration) {
+	request.block = true
+	request.timeout = timeout
+}
+
+// blocking returns the longest timeout of the blocking commands of the request if any.
+func (request *Request) blocking() (timeout time.Duration, ok bool) {
+	if request.block {
+		return request.timeout, true
+	}
+
+	for i := range request.commands {
+		d, b := request.commands[i].blocking()
+		if !b {
+			continue
+		}
+
+		if !ok || d == 0 || (timeout != 0 && d > timeout) {
+			timeout = d
+		}
+
+		ok = true
+	}
+
+	return
+}
+
+// sendBlocking sends the request over a dedicated connection so it doesn't stall the pipeline while waiting.
+func (conn *Conn) sendBlocking(ctx context.Context, request *Request, timeout time.Duration) error {
+	// the reply is expected once the command times out on the server
+	if timeout > 0 {
+		margin := conn.Options.ReadTimeout
+		if 0 == margin {
+			margin = DefaultBlockingTimeoutMargin
+		}
+
+		timeout += margin
+	}
+
+	return conn.dedicated(ctx, func(l *link) error {
+		request.ctx = ctx
+		return l.roundTrip(request, timeout)
+	})
+}
+
+// dedicated calls the function with a connection that isn't shared with the pipeline.
+// The connection is closed when the context is done which aborts whatever it was waiting for.
+func (conn *Conn) dedicated(ctx context.Context, f func(*link) error) (err error) {
+	if conn.State() == StateClosed {
+		return ErrConnectionUnavailable
+	}
+
+	l, err := conn.acquire()
+	if err != nil {
+		return &UnavailableError{Err: err}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			l.fail(ctx.Err())
+		case <-done:
+		}
+	}()
+
+	err = f(l)
+
+	close(done)
+	conn.release(l)
+
+	if e := ctx.Err(); e != nil && l.failure() != nil {
+		err = e
+	}
+
+	return
+}
+
+// acquire returns an idle dedicated connection or establishes a new one.
+func (conn *Conn) acquire() (l *link, err error) {
+	conn.mu.Lock()
+	if n := len(conn.spare); n != 0 {
+		l = conn.spare[n-1]
+		conn.spare = conn.spare[:n-1]
+	}
+
+	conn.mu.Unlock()
+
+	if l != nil {
+		return
+	}
+
+	return conn.connect()
+}
+
+// release keeps the dedicated connection for later unless it failed or enough are idle already.
+func (conn *Conn) release(l *link) {
+	if l.failure() != nil {
+		return
+	}
+
+	idle := conn.MaximumIdleBlockingConnections
+	if 0 == idle {
+		idle = DefaultMaximumIdleBlockingConnections
+	}
+
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+
+	if conn.State() == StateClosed || len(conn.spare) >= idle {
+		l.fail(io.ErrClosedPipe)
+		return
+	}
+
+	conn.spare = append(conn.spare, l)
+}
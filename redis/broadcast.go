@@ -0,0 +1,136 @@
+// Copyright (c) 2015 Datacratic. All rights reserved.
+
+package redis
+
+import (
+	"context"
+	"sort"
+)
+
+// NodeSet defines which nodes of a cluster are reached by a broadcast.
+type NodeSet int
+
+const (
+	// Masters selects the nodes serving slots.
+	Masters NodeSet = iota
+	// AllNodes selects the masters along with their replicas.
+	AllNodes
+)
+
+// NodeResult holds the copy of a request broadcast to a node once sent.
+// Err is the error returned when sending the request, if any.
+type NodeResult struct {
+	Address string
+	Request *Request
+	Err     error
+}
+
+// ForEachMaster calls f concurrently for each master of the cluster, or the only node of a Redis database.
+// It waits for every call to return and returns the first error, if any.
+func (client *Client) ForEachMaster(ctx context.Context, f func(ctx context.Context, address string, node *Conn) error) error {
+	return client.forEach(ctx, Masters, f)
+}
+
+// ForEachNode calls f concurrently for each master and replica of the cluster.
+// It waits for every call to return and returns the first error, if any.
+func (client *Client) ForEachNode(ctx context.Context, f func(ctx context.Context, address string, node *Conn) error) error {
+	return client.forEach(ctx, AllNodes, f)
+}
+
+// Broadcast sends a copy of the request to each selected node concurrently.
+// Results are sorted by address and hold the replies of each node which can be aggregated as needed, e.g. for DBSIZE or INFO.
+func (client *Client) Broadcast(ctx context.Context, request *Request, nodes NodeSet) (results []NodeResult, err error) {
+	targets, err := client.targets(ctx, nodes)
+	if err != nil {
+		return
+	}
+
+	results = make([]NodeResult, 0, len(targets))
+	for address := range targets {
+		results = append(results, NodeResult{
+			Address: address,
+			Request: request.copy(),
+		})
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		return results[i].Address < results[j].Address
+	})
+
+	index := make(map[string]*NodeResult, len(results))
+	for i := range results {
+		index[results[i].Address] = &results[i]
+	}
+
+	fanOut(ctx, targets, func(ctx context.Context, address string, node *Conn) error {
+		result := index[address]
+		result.Err = node.SendContext(ctx, result.Request)
+		return result.Err
+	})
+
+	return
+}
+
+func (client *Client) forEach(ctx context.Context, nodes NodeSet, f func(ctx context.Context, address string, node *Conn) error) (err error) {
+	targets, err := client.targets(ctx, nodes)
+	if err != nil {
+		return
+	}
+
+	return fanOut(ctx, targets, f)
+}
+
+// targets returns the selected nodes by address after discovering the cluster when needed.
+func (client *Client) targets(ctx context.Context, nodes NodeSet) (result map[string]*Conn, err error) {
+	state, err := client.discover(ctx)
+	if err != nil {
+		return
+	}
+
+	selected := make(map[*Conn]bool)
+	for _, node := range state.slots {
+		if node == nil || selected[node] {
+			continue
+		}
+
+		selected[node] = true
+		if nodes == AllNodes {
+			for _, replica := range state.replicas[node] {
+				selected[replica] = true
+			}
+		}
+	}
+
+	client.mu.Lock()
+	defer client.mu.Unlock()
+
+	result = make(map[string]*Conn)
+	for _, known := range []map[string]*Conn{client.nodes, client.replicas} {
+		for address, node := range known {
+			if selected[node] {
+				result[address] = node
+			}
+		}
+	}
+
+	return
+}
+
+// fanOut calls f concurrently for each node and returns the first error once they are all done.
+func fanOut(ctx context.Context, nodes map[string]*Conn, f func(ctx context.Context, address string, node *Conn) error) (err error) {
+	done := make(chan error, len(nodes))
+	for address, node := range nodes {
+		address, node := address, node
+		go func() {
+			done <- f(ctx, address, node)
+		}()
+	}
+
+	for i := 0; i < len(nodes); i++ {
+		if e := <-done; e != nil && err == nil {
+			err = e
+		}
+	}
+
+	return
+}
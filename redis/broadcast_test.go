@@ -0,0 +1,93 @@
+// Copyright (c) 2015 Datacratic. All rights reserved.
+
+package redis
+
+import (
+	"context"
+	"reflect"
+	"sync/atomic"
+	"testing"
+)
+
+func TestBroadcast(t *testing.T) {
+	client := &Client{
+		scheme:   "tcp",
+		nodes:    make(map[string]*Conn),
+		replicas: make(map[string]*Conn),
+	}
+
+	state := &mapping{
+		shards:   true,
+		nodes:    make(map[string]*Conn),
+		replicas: make(map[*Conn][]*Conn),
+	}
+
+	node := func(reply string) *Conn {
+		db := new(mockDB)
+		db.result.WriteString(reply)
+		return &Conn{db: db}
+	}
+
+	a := node(":10\r\n")
+	b := node("-ERR failed\r\n")
+	c := node(":5\r\n")
+
+	client.nodes["tcp://127.0.0.1:7000"] = a
+	client.nodes["tcp://127.0.0.1:7001"] = b
+	client.replicas["tcp://127.0.0.1:7002"] = c
+	state.nodes = client.nodes
+	state.replicas[a] = []*Conn{c}
+
+	for i := range state.slots {
+		if i < len(state.slots)/2 {
+			state.slots[i] = a
+		} else {
+			state.slots[i] = b
+		}
+	}
+
+	client.state.Store(state)
+	defer client.Close()
+
+	results, err := client.Broadcast(context.Background(), NewRequest("DBSIZE"), AllNodes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var addresses []string
+	for _, result := range results {
+		addresses = append(addresses, result.Address)
+	}
+
+	expected := []string{"tcp://127.0.0.1:7000", "tcp://127.0.0.1:7001", "tcp://127.0.0.1:7002"}
+	if !reflect.DeepEqual(addresses, expected) {
+		t.Fatalf("unexpected nodes '%v' instead of '%v'", addresses, expected)
+	}
+
+	if reply, err := results[0].Request.Result(0); err != nil || reply != int64(10) {
+		t.Fatal(err, reply)
+	}
+
+	if results[1].Err == nil {
+		t.Fatal("error wasn't reported")
+	}
+
+	if reply, err := results[2].Request.Result(0); err != nil || reply != int64(5) {
+		t.Fatal(err, reply)
+	}
+
+	// replicas are left out
+	var n int32
+	err = client.ForEachMaster(context.Background(), func(ctx context.Context, address string, node *Conn) error {
+		if node == c {
+			t.Error("replica was selected")
+		}
+
+		atomic.AddInt32(&n, 1)
+		return nil
+	})
+
+	if err != nil || n != 2 {
+		t.Fatal(err, n)
+	}
+}
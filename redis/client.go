@@ -3,6 +3,8 @@
 package redis
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"math/rand"
@@ -24,6 +26,7 @@ var DefaultMaximumSlotUpdates = 4
 // Client implements a client to the Redis database or cluster.
 // This client always starts as a normal connection and migrates to handling cluster transparently when required.
 // The first address is used to connect while the others can be used as alternatives in case of failure.
+// Addresses are URLs as understood by ParseURL and the options of the first one also apply to discovered nodes.
 type Client struct {
 	Address                   []string
 	MaximumRedirections       int
@@ -32,22 +35,67 @@ type Client struct {
 	MaximumPendingRequests    int
 	MaximumConnectionRetries  int
 	RetryTimeout              time.Duration
+	Options                   DialOptions
 
-	lua map[string]string
+	// ReadFrom defines which nodes of a cluster serve the read-only requests.
+	// Replicas are sent READONLY and reads fall back to the master when they fail.
+	ReadFrom ReadPolicy
+
+	// RefreshInterval defines the period at which the topology of a cluster is reloaded when not 0.
+	// It is also reloaded when nodes fail but no more often than MinimumRefreshInterval.
+	RefreshInterval        time.Duration
+	MinimumRefreshInterval time.Duration
+
+	// RefreshNodes defines the number of nodes that are asked for the topology to agree on.
+	RefreshNodes int
+
+	// OnTopologyChange is called in the background when nodes join or leave the cluster or slots move.
+	OnTopologyChange func(TopologyEvent)
+
+	// Retry defines how requests failing with transient errors are retried across redirections.
+	// They are not retried when nil.
+	Retry *RetryPolicy
+
+	// Commands describes where the keys of commands are found to route them to the right node.
+	// It is loaded from the cluster with COMMAND when not set.
+	Commands CommandTable
+
+	lua     map[string]string
+	scheme  string
+	options DialOptions
+
+	// seeds holds the names of the nodes given in Address which are kept to find the cluster again.
+	seeds map[string]bool
+
+	// asked counts the requests being sent with ASKING to nodes that may not be in the mapping.
+	asked map[*Conn]int
+
+	// listeners are notified each time the mapping of slots changes.
+	listeners map[int]func()
+	watchers  int
 
 	state atomic.Value
 	mu    sync.Mutex
 	once  sync.Once
 	nodes map[string]*Conn
+
+	// replicas holds the connections to replicas for reuse and closing.
+	replicas map[string]*Conn
+
+	// trigger wakes up the background refresher which stops when quit is closed.
+	trigger chan struct{}
+	quit    chan struct{}
 }
 
 type mapping struct {
-	id     int64
-	missed int
-	shards bool
-	closed bool
-	nodes  map[string]*Conn
-	slots  [16384]*Conn
+	id       int64
+	missed   int
+	shards   bool
+	closed   bool
+	commands CommandTable
+	nodes    map[string]*Conn
+	replicas map[*Conn][]*Conn
+	slots    [16384]*Conn
 }
 
 func (client *Client) initialize() {
@@ -57,17 +105,41 @@ func (client *Client) initialize() {
 		address = []string{"tcp://127.0.0.1:6379"}
 	}
 
+	// nodes discovered later on are reached the same way as the first one
+	client.scheme = "tcp"
+	client.options = client.Options
+	if seed, err := parseURL(address[0], client.Options); err == nil {
+		client.options = seed.Options
+		if u, _ := url.Parse(address[0]); u.Scheme == "redis" {
+			client.scheme = "redis"
+		}
+
+		if seed.Options.TLSConfig != nil {
+			client.scheme = "rediss"
+		}
+	}
+
 	client.nodes = make(map[string]*Conn)
+	client.replicas = make(map[string]*Conn)
+	client.seeds = make(map[string]bool)
 
-	// prepare to (lazy) connect with all the nodes
+	// prepare to (lazy) connect with all the nodes named as they are once discovered
 	for i := range address {
-		client.nodes[address[i]] = client.connect(address[i])
+		name := client.name(address[i])
+		if !client.seeds[name] {
+			client.seeds[name] = true
+			client.nodes[name] = client.connect(address[i])
+		}
 	}
 
 	// create the initial state from the first address given in parameters
-	primary := client.nodes[address[0]]
+	primary := client.nodes[client.name(address[0])]
 	state := &mapping{
-		nodes: client.nodes,
+		nodes: make(map[string]*Conn, len(client.nodes)),
+	}
+
+	for name, node := range client.nodes {
+		state.nodes[name] = node
 	}
 
 	for i, n := 0, len(state.slots); i < n; i++ {
@@ -80,8 +152,13 @@ func (client *Client) initialize() {
 
 // Do executes the specified command (with optional arguments) to the Redis instance and waits to decode the reply.
 func (client *Client) Do(name string, args ...interface{}) (result interface{}, err error) {
+	return client.DoContext(context.Background(), name, args...)
+}
+
+// DoContext executes the specified command (with optional arguments) to the Redis instance and waits to decode the reply or for the context to be done.
+func (client *Client) DoContext(ctx context.Context, name string, args ...interface{}) (result interface{}, err error) {
 	request := NewRequest(name, args...)
-	if err = client.Send(request); err == nil {
+	if err = client.SendContext(ctx, request); err == nil {
 		result = request.commands[len(request.commands)-1].result
 	}
 
@@ -90,41 +167,88 @@ func (client *Client) Do(name string, args ...interface{}) (result interface{},
 
 // Send sends the specified request to the Redis instance and waits for the reply.
 func (client *Client) Send(request *Request) (err error) {
-	value := client.state.Load()
-	if value == nil {
-		client.once.Do(client.initialize)
-		value = client.state.Load()
-	}
+	return client.SendContext(context.Background(), request)
+}
 
-	state := value.(*mapping)
-	if state.closed {
-		log.Panicf("client closed")
+// SendContext sends the specified request to the Redis instance and waits for the reply or for the context to be done.
+// Redirections are not followed anymore once the context is done.
+// On a cluster, MGET, MSET, DEL, EXISTS, UNLINK and TOUCH are split per slot and their replies merged back.
+// Requests failing with transient errors such as TRYAGAIN or CLUSTERDOWN are sent again according to the Retry policy, if any.
+func (client *Client) SendContext(ctx context.Context, request *Request) (err error) {
+	state := client.current()
+
+	// each part is retried on its own
+	if state.shards {
+		if parts := request.split(); parts != nil {
+			return sendParts(ctx, client, request, parts)
+		}
 	}
 
+	return client.Retry.do(ctx, request, client.send)
+}
+
+func (client *Client) send(ctx context.Context, request *Request) (err error) {
+	state := client.current()
+
 	// figure out where this request should be sent
 	slot := 0
 	if state.shards {
-		slot = request.slot()
+		slot = request.slot(state.commands)
 	}
 
 	node := state.slots[slot]
 
+	// offload reads to the replicas and fall back to the master on failure
+	if state.shards && client.ReadFrom != ReadFromMaster && request.readOnly(state.commands) {
+		fallback := false
+		if fallback, err = read(ctx, client.reader(state, node), request); !fallback {
+			return
+		}
+	}
+
 	redirect := client.MaximumRedirections
 	if 0 == redirect {
 		redirect = DefaultMaximumRedirections
 	}
 
+	asking := false
 	for i := 0; i < redirect; i++ {
 		if node == nil {
 			break
 		}
 
-		if err = node.Send(request); err == nil {
+		if asking {
+			err = client.ask(ctx, node, request)
+			client.release(node)
+			asking = false
+		} else {
+			err = node.SendContext(ctx, request)
+		}
+
+		if err == nil {
+			break
+		}
+
+		// abandoned?
+		if ctx.Err() != nil {
 			break
 		}
 
-		// done?
-		if !request.redirect {
+		// the node left the cluster in the meantime
+		if node.State() == StateClosed {
+			if next := client.state.Load().(*mapping); next != state && next.shards {
+				state = next
+				node = state.slots[slot]
+				continue
+			}
+		}
+
+		// done? unless multi-key commands reveal a cluster
+		if !request.redirect && (state.shards || ErrorKind(err) != "CROSSSLOT") {
+			if state.shards && (IsClusterDown(err) || IsTimeout(err) || errors.Is(err, ErrConnectionUnavailable)) {
+				client.refreshSoon()
+			}
+
 			break
 		}
 
@@ -134,29 +258,48 @@ func (client *Client) Send(request *Request) (err error) {
 				return
 			}
 
-			slot = request.slot()
+			if parts := request.split(); parts != nil {
+				return sendParts(ctx, client, request, parts)
+			}
+
+			slot = request.slot(state.commands)
 			node = state.slots[slot]
 			continue
 		}
 
-		// already connected?
-		if node = state.nodes[request.address]; node != nil {
-			if request.moved {
-				state, err = client.update(slot, node)
-			}
+		// the slot is being migrated so only this request goes to the target and the slots stay the same
+		if !request.moved {
+			node = client.lookup(request.address)
+			asking = true
+			continue
+		}
 
+		// already connected?
+		if node = state.nodes[client.url(request.address)]; node != nil {
+			state, err = client.update(slot, node)
 			continue
 		}
 
-		state, node, err = client.redirect(request)
+		state, node, err = client.redirect(request.address)
 		if err != nil {
 			node = client.random()
 		}
 	}
 
+	// the target of an ASK wasn't reached
+	if asking {
+		client.release(node)
+	}
+
 	return
 }
 
+// PubSub creates a subscriber that connects to the first node of the Redis database or cluster.
+// Messages published on a cluster are propagated to every node.
+func (client *Client) PubSub() *PubSub {
+	return client.current().slots[0].PubSub()
+}
+
 // LuaScript loads a script into the script cache.
 func (client *Client) LuaScript(code string) (id string, err error) {
 	value := client.state.Load()
@@ -170,38 +313,35 @@ func (client *Client) LuaScript(code string) (id string, err error) {
 		log.Panicf("client closed")
 	}
 
+	// load the script on all known connections without holding the lock while waiting for them
 	client.mu.Lock()
-	defer client.mu.Unlock()
-
-	done := make(chan func() (interface{}, error))
-
-	// load the script on all known connections
-	for name := range client.nodes {
-		name := name
-		go func() {
-			node := client.nodes[name]
-			result, err := node.LuaScript(code)
-			done <- func() (interface{}, error) {
-				return result, err
-			}
-		}()
+	nodes := make(map[string]*Conn, len(client.nodes))
+	for address, node := range client.nodes {
+		nodes[address] = node
 	}
 
-	// wait for the result
-	for i, n := 0, len(client.nodes); i < n; i++ {
-		f := <-done
-		var result interface{}
-		result, err = f()
+	client.mu.Unlock()
+
+	var lock sync.Mutex
+	err = fanOut(context.Background(), nodes, func(ctx context.Context, address string, node *Conn) error {
+		key, err := node.LuaScript(code)
 		if err != nil {
-			return
+			return err
 		}
 
-		key := result.(string)
+		lock.Lock()
+		defer lock.Unlock()
+
 		if id == "" || id == key {
 			id = key
-		} else {
-			err = fmt.Errorf("script SHA1 doesn't match '%s' vs. '%s'", id, key)
+			return nil
 		}
+
+		return fmt.Errorf("script SHA1 doesn't match '%s' vs. '%s'", id, key)
+	})
+
+	if err != nil {
+		return
 	}
 
 	if id != "" {
@@ -211,6 +351,9 @@ func (client *Client) LuaScript(code string) (id string, err error) {
 	}
 
 	// remember this script for new connections
+	client.mu.Lock()
+	defer client.mu.Unlock()
+
 	if client.lua == nil {
 		client.lua = make(map[string]string)
 	}
@@ -232,7 +375,17 @@ func (client *Client) Close() {
 		item.Close()
 	}
 
+	for _, item := range client.replicas {
+		item.Close()
+	}
+
+	if client.quit != nil {
+		close(client.quit)
+		client.quit = nil
+	}
+
 	client.nodes = nil
+	client.replicas = nil
 	client.state.Store(&mapping{
 		closed: true,
 	})
@@ -244,21 +397,26 @@ func (client *Client) connect(address string) *Conn {
 		lua[key] = code
 	}
 
-	return &Conn{
-		MaximumConcurrentRequests: client.MaximumConcurrentRequests,
-		MaximumPendingRequests:    client.MaximumPendingRequests,
-		MaximumConnectionRetries:  client.MaximumConnectionRetries,
-		RetryTimeout:              client.RetryTimeout,
-		db: dialerFunc(func() (net.Conn, error) {
-			u, err := url.Parse(address)
-			if err != nil {
-				return nil, err
-			}
+	var conn *Conn
 
-			return net.Dial(u.Scheme, u.Host+u.Path)
-		}),
-		lua: lua,
+	// options found in the URL take precedence over the ones of the client
+	endpoint, err := parseURL(address, client.options)
+	if err != nil {
+		conn = &Conn{
+			db: dialerFunc(func() (net.Conn, error) {
+				return nil, err
+			}),
+		}
+	} else {
+		conn = endpoint.Dial()
 	}
+
+	conn.MaximumConcurrentRequests = client.MaximumConcurrentRequests
+	conn.MaximumPendingRequests = client.MaximumPendingRequests
+	conn.MaximumConnectionRetries = client.MaximumConnectionRetries
+	conn.RetryTimeout = client.RetryTimeout
+	conn.lua = lua
+	return conn
 }
 
 func (client *Client) migrate() (state *mapping, err error) {
@@ -272,8 +430,22 @@ func (client *Client) migrate() (state *mapping, err error) {
 		return
 	}
 
+	// learn where the keys of commands are found to route them
+	commands := client.Commands
+	if commands == nil {
+		if commands, err = LoadCommands(context.Background(), state.slots[0]); err != nil {
+			log.Println("using built-in commands:", err)
+			commands, err = DefaultCommands, nil
+		}
+	}
+
 	// update the mapping then
-	state, err = client.reconfigure(state, state.slots[0])
+	last := *state
+	last.commands = commands
+	if state, err = client.reconfigure(&last, state.slots[0]); err == nil {
+		client.startRefresher()
+	}
+
 	return
 }
 
@@ -287,45 +459,126 @@ func (client *Client) update(slot int, node *Conn) (state *mapping, err error) {
 	}
 
 	// check if we can simply update the state or if a full refresh is required
-	state = client.state.Load().(*mapping)
-	state.missed++
-	if state.missed < miss {
+	last := client.state.Load().(*mapping)
+	last.missed++
+	if last.missed < miss {
 		state = &mapping{
-			id:     state.id + 1,
-			shards: true,
-			nodes:  state.nodes,
-			slots:  state.slots,
+			id:       last.id + 1,
+			missed:   last.missed,
+			shards:   true,
+			commands: last.commands,
+			nodes:    last.nodes,
+			replicas: last.replicas,
+			slots:    last.slots,
 		}
 
 		// update the slot in the new copy of the state
 		state.slots[slot] = node
 
 		client.state.Store(state)
+		client.notify()
+		client.prune(last, state)
 		return
 	}
 
-	state, err = client.reconfigure(state, node)
+	state, err = client.reconfigure(last, node)
 	return
 }
 
-func (client *Client) redirect(request *Request) (state *mapping, node *Conn, err error) {
+func (client *Client) redirect(address string) (state *mapping, node *Conn, err error) {
 	client.mu.Lock()
 	defer client.mu.Unlock()
 
 	state = client.state.Load().(*mapping)
 
 	// already connected?
-	if node = state.nodes[request.address]; node != nil {
+	name := client.url(address)
+	if node = state.nodes[name]; node != nil {
 		return
 	}
 
-	// connect to that new node then
-	node = client.connect(request.address)
+	// connect to that new node then unless already reached by a previous ASK
+	if node = client.nodes[name]; node == nil {
+		node = client.connect(name)
+	}
 
 	state, err = client.reconfigure(state, node)
 	return
 }
 
+// ask sends the request to the node importing its slot with each command preceded by ASKING.
+func (client *Client) ask(ctx context.Context, node *Conn, request *Request) (err error) {
+	asking := &Request{
+		commands: make([]command, 0, 2*len(request.commands)),
+	}
+
+	for i := range request.commands {
+		asking.Add("ASKING")
+		asking.commands = append(asking.commands, request.commands[i])
+	}
+
+	err = node.SendContext(ctx, asking)
+
+	// the replies of the commands are only valid once received
+	if err == nil || ctx.Err() == nil {
+		for i := range request.commands {
+			request.commands[i] = asking.commands[2*i+1]
+		}
+
+		request.moved = asking.moved
+		request.redirect = asking.redirect
+		request.address = asking.address
+		request.err = asking.err
+	}
+
+	return
+}
+
+// lookup returns the node at the specified host:port address without changing the mapping of slots.
+// The node isn't closed when pruning the nodes that aren't in the mapping until it is released.
+func (client *Client) lookup(address string) (node *Conn) {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+
+	name := client.url(address)
+	if node = client.nodes[name]; node == nil {
+		node = client.connect(name)
+		client.nodes[name] = node
+	}
+
+	if client.asked == nil {
+		client.asked = make(map[*Conn]int)
+	}
+
+	client.asked[node]++
+	return
+}
+
+// release lets the next pruning close a node returned by lookup once it isn't used anymore.
+func (client *Client) release(node *Conn) {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+
+	if client.asked[node]--; client.asked[node] <= 0 {
+		delete(client.asked, node)
+	}
+}
+
+// url returns the name of the node at the specified host:port address.
+func (client *Client) url(address string) string {
+	return client.scheme + "://" + address
+}
+
+// name returns the name of the node at the specified URL as given to discovered nodes i.e. without credentials nor options.
+func (client *Client) name(address string) string {
+	endpoint, err := parseURL(address, client.options)
+	if err != nil || endpoint.Network != "tcp" {
+		return address
+	}
+
+	return client.url(endpoint.Address)
+}
+
 func (client *Client) random() (node *Conn) {
 	client.mu.Lock()
 	defer client.mu.Unlock()
@@ -351,14 +604,26 @@ func (client *Client) reconfigure(last *mapping, node *Conn) (next *mapping, err
 		return
 	}
 
+	return client.apply(last, result)
+}
+
+// apply creates the next mapping from the reply of CLUSTER SLOTS.
+func (client *Client) apply(last *mapping, result interface{}) (next *mapping, err error) {
+	groups, ok := result.([]interface{})
+	if !ok {
+		err = fmt.Errorf("redis returned an invalid reply to CLUSTER SLOTS '%v'", result)
+		return
+	}
+
 	next = &mapping{
-		id:     last.id + 1,
-		shards: true,
-		nodes:  make(map[string]*Conn),
+		id:       last.id + 1,
+		shards:   true,
+		commands: last.commands,
+		nodes:    make(map[string]*Conn),
+		replicas: make(map[*Conn][]*Conn),
 	}
 
 	// prepare the next state with only read access to the last state
-	groups := result.([]interface{})
 	for i := range groups {
 		item := groups[i].([]interface{})
 		a := item[0].(int64)
@@ -366,11 +631,15 @@ func (client *Client) reconfigure(last *mapping, node *Conn) (next *mapping, err
 		m := item[2].([]interface{})
 		addr := string(m[0].([]byte))
 		port := m[1].(int64)
-		name := fmt.Sprintf("tcp://%s:%d", addr, port)
+		name := client.url(fmt.Sprintf("%s:%d", addr, port))
 
 		conn, ok := next.nodes[name]
 		if !ok {
 			conn, ok = last.nodes[name]
+			if !ok {
+				conn, ok = client.nodes[name]
+			}
+
 			if !ok {
 				conn = client.connect(name)
 			}
@@ -378,6 +647,11 @@ func (client *Client) reconfigure(last *mapping, node *Conn) (next *mapping, err
 			next.nodes[name] = conn
 		}
 
+		// the same master can own several ranges of slots
+		if _, ok := next.replicas[conn]; !ok {
+			next.replicas[conn] = client.replicasOf(item[3:])
+		}
+
 		// fill slots
 		for j := a; j <= b; j++ {
 			next.slots[j] = conn
@@ -390,9 +664,79 @@ func (client *Client) reconfigure(last *mapping, node *Conn) (next *mapping, err
 	}
 
 	client.state.Store(next)
+	client.notify()
+	client.prune(last, next)
+	return
+}
+
+// replicasOf returns the connections to the replicas listed by CLUSTER SLOTS.
+func (client *Client) replicasOf(items []interface{}) (result []*Conn) {
+	for _, item := range items {
+		m, ok := item.([]interface{})
+		if !ok || len(m) < 2 {
+			continue
+		}
+
+		addr, _ := m[0].([]byte)
+		port, _ := m[1].(int64)
+		name := client.url(fmt.Sprintf("%s:%d", addr, port))
+
+		conn, ok := client.replicas[name]
+		if !ok {
+			conn = client.connect(name)
+			conn.Options.ReadOnly = true
+			client.replicas[name] = conn
+		}
+
+		result = append(result, conn)
+	}
+
 	return
 }
 
+// current returns the mapping of slots after initializing the client when needed.
+func (client *Client) current() *mapping {
+	value := client.state.Load()
+	if value == nil {
+		client.once.Do(client.initialize)
+		value = client.state.Load()
+	}
+
+	state := value.(*mapping)
+	if state.closed {
+		log.Panicf("client closed")
+	}
+
+	return state
+}
+
+// watch registers a function called each time the mapping of slots changes until unwatch is called.
+func (client *Client) watch(f func()) (unwatch func()) {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+
+	if client.listeners == nil {
+		client.listeners = make(map[int]func())
+	}
+
+	id := client.watchers
+	client.watchers++
+	client.listeners[id] = f
+
+	return func() {
+		client.mu.Lock()
+		defer client.mu.Unlock()
+		delete(client.listeners, id)
+	}
+}
+
+// notify calls the listeners in the background since the lock is held.
+func (client *Client) notify() {
+	for _, f := range client.listeners {
+		go f()
+	}
+}
+
 func init() {
 	blueprint.Register(Client{})
 }
@@ -4,6 +4,7 @@ package redis
 
 import (
 	"bytes"
+	"crypto/tls"
 	"fmt"
 	"io/ioutil"
 	"log"
@@ -21,12 +22,14 @@ type Cluster struct {
 	base  int
 	root  string
 	test  bool
+	tls   *tls.Config
 }
 
 // NewCluster creates a local Redis cluster of the specified size.
 // Each instance is assigned a unique port that starts at 'base' i.e. instance i uses port base+i and base+i+10000.
 // Slots are allocated evently between nodes.
 // Node configuration is used to launch each instance and saved under 'root'.
+// When TLS is configured with 'tls-cert-file', ports are used for TLS connections and the cluster bus is encrypted.
 func NewCluster(size, base int, root string, config map[string]string) (result *Cluster, err error) {
 	if size < 3 {
 		log.Panicf("invalid cluster size")
@@ -51,11 +54,22 @@ func NewCluster(size, base int, root string, config map[string]string) (result *
 	config["cluster-enabled"] = "yes"
 	config["cluster-node-timeout"] = "5000"
 
+	secure := config["tls-cert-file"] != ""
+	if secure {
+		config["tls-cluster"] = "yes"
+	}
+
 	for i := 0; i < size; i++ {
 		port := base + i
 
 		// create an instance
-		config["port"] = fmt.Sprintf("%d", port)
+		if secure {
+			config["port"] = "0"
+			config["tls-port"] = fmt.Sprintf("%d", port)
+		} else {
+			config["port"] = fmt.Sprintf("%d", port)
+		}
+
 		config["cluster-config-file"] = fmt.Sprintf("%s/%d/nodes.conf", root, port)
 		os.Mkdir(fmt.Sprintf("%s/%d", root, port), os.ModePerm)
 		cluster.db[i], err = New("", config)
@@ -144,8 +158,16 @@ func (cluster *Cluster) Dial() (result *Client) {
 		Address: make([]string, n),
 	}
 
+	result.Options.TLSConfig = cluster.tls
+
 	for i := 0; i < n; i++ {
-		result.Address[i] = fmt.Sprintf("tcp://127.0.0.1:%d", cluster.base+i)
+		endpoint := &Endpoint{
+			Network: "tcp",
+			Address: fmt.Sprintf("127.0.0.1:%d", cluster.base+i),
+			Options: result.Options,
+		}
+
+		result.Address[i] = endpoint.String()
 	}
 
 	return
@@ -170,6 +192,99 @@ func (cluster *Cluster) Close() {
 	}
 }
 
+// Owner returns the index of the node that was initially allocated the specified slot.
+func (cluster *Cluster) Owner(slot int) int {
+	k := 16384 / cluster.size
+	if i := slot / k; i < cluster.size {
+		return i
+	}
+
+	return cluster.size - 1
+}
+
+// ID returns the identifier of the specified node in the cluster.
+func (cluster *Cluster) ID(node int) (id string, err error) {
+	result, err := cluster.nodes[node].Do("CLUSTER", "NODES")
+	if err != nil {
+		return
+	}
+
+	for _, line := range strings.Split(string(result.([]byte)), "\n") {
+		if fields := strings.Fields(line); len(fields) > 2 && strings.Contains(fields[2], "myself") {
+			id = fields[0]
+			return
+		}
+	}
+
+	err = fmt.Errorf("node %d doesn't know itself", node)
+	return
+}
+
+// StartMigration marks the slot as being imported by the target node and migrated by the source node.
+// Keys must then be moved with MigrateKeys.
+func (cluster *Cluster) StartMigration(slot, source, target int) (err error) {
+	from, err := cluster.ID(source)
+	if err != nil {
+		return
+	}
+
+	to, err := cluster.ID(target)
+	if err != nil {
+		return
+	}
+
+	if _, err = cluster.nodes[target].Do("CLUSTER", "SETSLOT", slot, "IMPORTING", from); err != nil {
+		return
+	}
+
+	_, err = cluster.nodes[source].Do("CLUSTER", "SETSLOT", slot, "MIGRATING", to)
+	return
+}
+
+// MigrateKeys moves the keys of the slot from the source node to the target node with MIGRATE.
+func (cluster *Cluster) MigrateKeys(slot, source, target int) (err error) {
+	for {
+		result, err := cluster.nodes[source].Do("CLUSTER", "GETKEYSINSLOT", slot, 100)
+		if err != nil {
+			return err
+		}
+
+		keys := result.([]interface{})
+		if len(keys) == 0 {
+			return nil
+		}
+
+		args := []interface{}{"127.0.0.1", cluster.base + target, "", 0, 5000, "KEYS"}
+		if _, err = cluster.nodes[source].Do("MIGRATE", append(args, keys...)...); err != nil {
+			return err
+		}
+	}
+}
+
+// FinishMigration assigns the slot to the target node on every node of the cluster.
+func (cluster *Cluster) FinishMigration(slot, target int) (err error) {
+	id, err := cluster.ID(target)
+	if err != nil {
+		return
+	}
+
+	// the target must be first to avoid a redirection loop
+	order := []int{target}
+	for i := range cluster.nodes {
+		if i != target {
+			order = append(order, i)
+		}
+	}
+
+	for _, i := range order {
+		if _, err = cluster.nodes[i].Do("CLUSTER", "SETSLOT", slot, "NODE", id); err != nil {
+			return
+		}
+	}
+
+	return
+}
+
 func (cluster *Cluster) ready() bool {
 	for _, item := range cluster.nodes {
 		result, err := item.Do("CLUSTER", "INFO")
@@ -246,6 +361,11 @@ func crc16(key []byte) (result uint16) {
 }
 
 func slot(key []byte) int {
+	return int(crc16(hashTag(key))) % 16384
+}
+
+// hashTag returns the part of the key that is hashed i.e. the text between the first { and the next } when not empty.
+func hashTag(key []byte) []byte {
 	if i := bytes.IndexByte(key, '{'); i >= 0 {
 		sub := key[i+1:]
 		if j := bytes.IndexByte(sub, '}'); j >= 1 {
@@ -253,5 +373,5 @@ func slot(key []byte) int {
 		}
 	}
 
-	return int(crc16(key)) % 16384
+	return key
 }
@@ -2,7 +2,13 @@
 
 package redis
 
-import "testing"
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
 
 func TestHash(t *testing.T) {
 	if crc16([]byte("123456789")) != 0x31C3 {
@@ -87,3 +93,321 @@ func TestCluster(t *testing.T) {
 	test("{foo}bar", "foo")
 	test("foo{bar}", "bar")
 }
+
+func TestTLSCluster(t *testing.T) {
+	if !clusterSupported() {
+		t.Skip("redis-server doesn't support clusters")
+		return
+	}
+
+	cluster, err := newTestTLSCluster(3)
+	if err != nil {
+		t.Skip("redis-server doesn't support TLS")
+		return
+	}
+
+	defer cluster.Close()
+
+	client := cluster.Dial()
+	defer client.Close()
+
+	// keys are spread over all nodes which are discovered over TLS
+	for _, key := range []string{"a", "b", "c", "d", "e"} {
+		if result, err := client.Do("SET", key, key); err != nil || result != OK {
+			t.Fatal(err, result)
+		}
+	}
+}
+
+func TestShardedPubSub(t *testing.T) {
+	if !clusterSupported() {
+		t.Skip("redis-server doesn't support clusters")
+		return
+	}
+
+	cluster, err := NewTestCluster(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer cluster.Close()
+
+	client := cluster.Dial()
+	defer client.Close()
+
+	// a fresh client subscribes on the first node and follows the redirections to the others
+	sps := client.ShardedPubSub()
+	defer sps.Close()
+
+	channels := []string{"a", "b", "c", "d", "e"}
+	if err := sps.SSubscribe(channels...); err != nil {
+		t.Fatal(err)
+	}
+
+	received := make(map[string]bool)
+	for len(received) != len(channels) {
+		for _, channel := range channels {
+			if _, err := client.Do("SPUBLISH", channel, channel); err != nil {
+				t.Skip("redis-server doesn't support sharded pub/sub")
+				return
+			}
+		}
+
+		select {
+		case m := <-sps.Messages():
+			if m.Kind == "smessage" {
+				received[m.Channel] = true
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("only received messages on %v", received)
+		}
+	}
+}
+
+func TestClusterTx(t *testing.T) {
+	if !clusterSupported() {
+		t.Skip("redis-server doesn't support clusters")
+		return
+	}
+
+	cluster, err := NewTestCluster(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer cluster.Close()
+
+	client := cluster.Dial()
+	defer client.Close()
+
+	ctx := context.Background()
+	result, err := client.Tx(ctx, func(tx *Tx) error {
+		tx.Queue("INCR", "{user}visits")
+		tx.Queue("SET", "{user}last", "now")
+		return nil
+	}, "{user}visits")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if reply, err := result.Result(0); err != nil || reply != int64(1) {
+		t.Fatal(err, reply)
+	}
+
+	_, err = client.Tx(ctx, func(tx *Tx) error {
+		tx.Queue("SET", "elsewhere", 1)
+		return nil
+	}, "{user}visits")
+
+	if err != ErrCrossSlot {
+		t.Fatal(err)
+	}
+}
+
+func TestClusterMultiKey(t *testing.T) {
+	if !clusterSupported() {
+		t.Skip("redis-server doesn't support clusters")
+		return
+	}
+
+	cluster, err := NewTestCluster(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer cluster.Close()
+
+	client := cluster.Dial()
+	defer client.Close()
+
+	if result, err := client.Do("MSET", "a", 1, "b", 2, "c", 3); err != nil || result != OK {
+		t.Fatal(err, result)
+	}
+
+	expected := []interface{}{[]byte("3"), nil, []byte("1"), []byte("2")}
+	if result, err := client.Do("MGET", "c", "missing", "a", "b"); err != nil || !reflect.DeepEqual(result, expected) {
+		t.Fatal(err, result)
+	}
+
+	if result, err := client.Do("EXISTS", "a", "b", "missing"); err != nil || result != int64(2) {
+		t.Fatal(err, result)
+	}
+
+	if result, err := client.Do("DEL", "a", "b", "c"); err != nil || result != int64(3) {
+		t.Fatal(err, result)
+	}
+}
+
+func TestClusterMigration(t *testing.T) {
+	if !clusterSupported() {
+		t.Skip("redis-server doesn't support clusters")
+		return
+	}
+
+	cluster, err := NewTestCluster(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer cluster.Close()
+
+	client := cluster.Dial()
+	defer client.Close()
+
+	key := "{migrating}a"
+	hash := slot([]byte(key))
+	source := cluster.Owner(hash)
+	target := (source + 1) % 3
+
+	if result, err := client.Do("SET", key, "before"); err != nil || result != OK {
+		t.Fatal(err, result)
+	}
+
+	node := client.current().slots[hash]
+
+	if err := cluster.StartMigration(hash, source, target); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := cluster.MigrateKeys(hash, source, target); err != nil {
+		t.Fatal(err)
+	}
+
+	// keys moved already are asked to the target
+	if result, err := client.Do("GET", key); err != nil || string(result.([]byte)) != "before" {
+		t.Fatal(err, result)
+	}
+
+	// new keys are created on the target
+	if result, err := client.Do("SET", "{migrating}b", "during"); err != nil || result != OK {
+		t.Fatal(err, result)
+	}
+
+	request := NewRequest("ASKING")
+	request.Add("GET", "{migrating}b")
+	if err := cluster.nodes[target].Send(request); err != nil {
+		t.Fatal(err)
+	}
+
+	if result, err := request.Result(1); err != nil || string(result.([]byte)) != "during" {
+		t.Fatal(err, result)
+	}
+
+	// the slots aren't updated while migrating
+	if client.current().slots[hash] != node {
+		t.Fatal("slot was updated on ASK")
+	}
+
+	if err := cluster.FinishMigration(hash, target); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{key, "{migrating}b"} {
+		if result, err := client.Do("GET", name); err != nil || result == nil {
+			t.Fatal(err, result)
+		}
+	}
+
+	if client.current().slots[hash] == node {
+		t.Fatal("slot wasn't updated on MOVED")
+	}
+}
+
+func TestClusterScan(t *testing.T) {
+	if !clusterSupported() {
+		t.Skip("redis-server doesn't support clusters")
+		return
+	}
+
+	cluster, err := NewTestCluster(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer cluster.Close()
+
+	client := cluster.Dial()
+	defer client.Close()
+
+	expected := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("key:%d", i)
+		if result, err := client.Do("SET", key, i); err != nil || result != OK {
+			t.Fatal(err, result)
+		}
+
+		expected[key] = true
+	}
+
+	if result, err := client.Do("HSET", "hash", "a", 1, "b", 2); err != nil {
+		t.Fatal(err, result)
+	}
+
+	// keys are found on every node
+	keys := make(map[string]bool)
+	it := client.Scan(context.Background(), "key:*", 10, "string")
+	for it.Next() {
+		keys[it.Key()] = true
+	}
+
+	if err := it.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(keys, expected) {
+		t.Fatalf("found %d keys instead of %d", len(keys), len(expected))
+	}
+
+	fields := make(map[string]string)
+	it = client.HScan(context.Background(), "hash", "", 0)
+	for it.Next() {
+		fields[it.Key()] = string(it.Value())
+	}
+
+	if err := it.Err(); err != nil || len(fields) != 2 || fields["b"] != "2" {
+		t.Fatal(err, fields)
+	}
+}
+
+func TestClusterBroadcast(t *testing.T) {
+	if !clusterSupported() {
+		t.Skip("redis-server doesn't support clusters")
+		return
+	}
+
+	cluster, err := NewTestCluster(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer cluster.Close()
+
+	client := cluster.Dial()
+	defer client.Close()
+
+	for _, key := range []string{"a", "b", "c", "d", "e"} {
+		if result, err := client.Do("SET", key, key); err != nil || result != OK {
+			t.Fatal(err, result)
+		}
+	}
+
+	results, err := client.Broadcast(context.Background(), NewRequest("DBSIZE"), Masters)
+	if err != nil || len(results) != 3 {
+		t.Fatal(err, results)
+	}
+
+	total := int64(0)
+	for _, result := range results {
+		reply, err := result.Request.Result(0)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		total += reply.(int64)
+	}
+
+	if total != 5 {
+		t.Fatalf("unexpected total of %d keys", total)
+	}
+}
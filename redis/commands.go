@@ -0,0 +1,346 @@
+// Copyright (c) 2015 Datacratic. All rights reserved.
+
+package redis
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CommandInfo describes where the keys of a command are found in its arguments and how it behaves.
+// Positions count the name of the command as 0 like COMMAND INFO and a negative last key counts from the end.
+type CommandInfo struct {
+	Name     string
+	FirstKey int
+	LastKey  int
+	Step     int
+	ReadOnly bool
+	Write    bool
+	Blocking bool
+
+	// movable extracts the keys of commands whose positions depend on the arguments.
+	movable func(args []interface{}) []interface{}
+
+	// timeout extracts how long a blocking command waits when it does depend on its arguments.
+	timeout func(args []interface{}) (time.Duration, bool)
+}
+
+// CommandTable maps the upper-case name of commands to their description.
+type CommandTable map[string]*CommandInfo
+
+const (
+	flagReadOnly = 1 << iota
+	flagWrite
+	flagBlocking
+)
+
+func (table CommandTable) add(flags int, first, last, step int, names ...string) {
+	for _, name := range names {
+		table[name] = &CommandInfo{
+			Name:     name,
+			FirstKey: first,
+			LastKey:  last,
+			Step:     step,
+			ReadOnly: flags&flagReadOnly != 0,
+			Write:    flags&flagWrite != 0,
+			Blocking: flags&flagBlocking != 0,
+		}
+	}
+}
+
+func (table CommandTable) addMovable(flags int, movable func([]interface{}) []interface{}, names ...string) {
+	table.add(flags, 0, 0, 0, names...)
+	for _, name := range names {
+		table[name].movable = movable
+	}
+}
+
+func (table CommandTable) addTimeout(timeout func([]interface{}) (time.Duration, bool), names ...string) {
+	for _, name := range names {
+		table[name].timeout = timeout
+	}
+}
+
+// DefaultCommands holds the built-in description of the commands used when they can't be loaded from the Redis instance.
+var DefaultCommands = func() CommandTable {
+	table := make(CommandTable)
+
+	table.add(flagReadOnly, 1, 1, 1,
+		"GET", "GETRANGE", "SUBSTR", "STRLEN", "TYPE", "TTL", "PTTL", "EXPIRETIME", "PEXPIRETIME", "DUMP", "GETBIT", "BITCOUNT", "BITPOS",
+		"HGET", "HMGET", "HGETALL", "HKEYS", "HVALS", "HLEN", "HEXISTS", "HSTRLEN", "HRANDFIELD", "HSCAN",
+		"LRANGE", "LLEN", "LINDEX", "LPOS",
+		"SMEMBERS", "SISMEMBER", "SMISMEMBER", "SCARD", "SRANDMEMBER", "SSCAN",
+		"ZRANGE", "ZRANGEBYSCORE", "ZREVRANGE", "ZREVRANGEBYSCORE", "ZRANGEBYLEX", "ZREVRANGEBYLEX", "ZSCORE", "ZMSCORE",
+		"ZRANK", "ZREVRANK", "ZCARD", "ZCOUNT", "ZLEXCOUNT", "ZRANDMEMBER", "ZSCAN",
+		"XRANGE", "XREVRANGE", "XLEN", "XPENDING", "GEOPOS", "GEODIST", "GEOHASH", "GEOSEARCH", "GEORADIUS_RO", "GEORADIUSBYMEMBER_RO")
+	table.add(flagReadOnly, 1, -1, 1, "MGET", "EXISTS", "TOUCH", "PFCOUNT", "SINTER", "SUNION", "SDIFF")
+	table.add(flagReadOnly, 2, 2, 1, "OBJECT", "XINFO", "MEMORY")
+
+	table.add(flagWrite, 1, 1, 1,
+		"SET", "SETNX", "SETEX", "PSETEX", "GETSET", "GETDEL", "GETEX", "APPEND", "SETRANGE", "SETBIT", "BITFIELD",
+		"INCR", "INCRBY", "INCRBYFLOAT", "DECR", "DECRBY",
+		"EXPIRE", "PEXPIRE", "EXPIREAT", "PEXPIREAT", "PERSIST", "RESTORE",
+		"HSET", "HSETNX", "HMSET", "HDEL", "HINCRBY", "HINCRBYFLOAT",
+		"LPUSH", "RPUSH", "LPUSHX", "RPUSHX", "LPOP", "RPOP", "LSET", "LINSERT", "LREM", "LTRIM",
+		"SADD", "SREM", "SPOP",
+		"ZADD", "ZINCRBY", "ZREM", "ZREMRANGEBYSCORE", "ZREMRANGEBYRANK", "ZREMRANGEBYLEX", "ZPOPMIN", "ZPOPMAX",
+		"PFADD", "XADD", "XDEL", "XTRIM", "XACK", "XCLAIM", "XAUTOCLAIM", "XSETID", "GEOADD", "GEORADIUS", "GEORADIUSBYMEMBER")
+	table.add(flagWrite, 1, -1, 1, "DEL", "UNLINK", "PFMERGE", "SINTERSTORE", "SUNIONSTORE", "SDIFFSTORE")
+	table.add(flagWrite, 1, -1, 2, "MSET", "MSETNX")
+	table.add(flagWrite, 1, 2, 1, "RENAME", "RENAMENX", "COPY", "RPOPLPUSH", "LMOVE", "SMOVE", "ZRANGESTORE", "GEOSEARCHSTORE")
+	table.add(flagWrite, 2, 2, 1, "XGROUP")
+	table.add(flagWrite, 2, -1, 1, "BITOP")
+
+	table.add(flagWrite|flagBlocking, 1, -2, 1, "BLPOP", "BRPOP", "BZPOPMIN", "BZPOPMAX")
+	table.add(flagWrite|flagBlocking, 1, 2, 1, "BRPOPLPUSH", "BLMOVE")
+	table.add(flagBlocking, 0, 0, 0, "WAIT", "WAITAOF")
+
+	table.add(flagReadOnly, 0, 0, 0, "ECHO", "DBSIZE", "KEYS", "SCAN", "RANDOMKEY")
+	table.add(0, 0, 0, 0,
+		"PING", "INFO", "TIME", "LASTSAVE", "CLUSTER", "COMMAND", "CONFIG", "CLIENT", "SCRIPT", "FUNCTION", "FLUSHDB", "FLUSHALL",
+		"SELECT", "AUTH", "HELLO", "ASKING", "READONLY", "READWRITE", "MULTI", "EXEC", "DISCARD", "UNWATCH", "PUBLISH")
+	table.add(0, 1, -1, 1, "WATCH")
+	table.add(0, 1, 1, 1, "SPUBLISH")
+
+	table.addMovable(0, numKeys(1), "EVAL", "EVALSHA", "FCALL")
+	table.addMovable(flagReadOnly, numKeys(1), "EVAL_RO", "EVALSHA_RO", "FCALL_RO")
+	table.addMovable(flagWrite, withDestination(numKeys(1)), "ZUNIONSTORE", "ZINTERSTORE", "ZDIFFSTORE")
+	table.addMovable(flagReadOnly, numKeys(0), "ZUNION", "ZINTER", "ZDIFF", "ZINTERCARD", "SINTERCARD")
+	table.addMovable(flagWrite, numKeys(0), "LMPOP", "ZMPOP")
+	table.addMovable(flagWrite|flagBlocking, numKeys(1), "BLMPOP", "BZMPOP")
+	table.addMovable(flagReadOnly|flagBlocking, streamKeys, "XREAD")
+	table.addMovable(flagWrite|flagBlocking, streamKeys, "XREADGROUP")
+	table.addMovable(flagWrite, firstArgument, "SORT")
+	table.addMovable(flagReadOnly, firstArgument, "SORT_RO")
+
+	table.addTimeout(lastSeconds, "BLPOP", "BRPOP", "BZPOPMIN", "BZPOPMAX", "BRPOPLPUSH", "BLMOVE")
+	table.addTimeout(firstSeconds, "BLMPOP", "BZMPOP")
+	table.addTimeout(lastMilliseconds, "WAIT", "WAITAOF")
+	table.addTimeout(blockOption, "XREAD", "XREADGROUP")
+	return table
+}()
+
+// numKeys extracts the keys that follow their number found at the specified argument.
+func numKeys(i int) func([]interface{}) []interface{} {
+	return func(args []interface{}) []interface{} {
+		if i >= len(args) {
+			return nil
+		}
+
+		n, err := strconv.Atoi(argText(args[i]))
+		if err != nil || n <= 0 || i+1+n > len(args) {
+			return nil
+		}
+
+		return args[i+1 : i+1+n]
+	}
+}
+
+// withDestination adds the destination key found first to the other keys.
+func withDestination(f func([]interface{}) []interface{}) func([]interface{}) []interface{} {
+	return func(args []interface{}) []interface{} {
+		if len(args) == 0 {
+			return nil
+		}
+
+		return append([]interface{}{args[0]}, f(args)...)
+	}
+}
+
+// streamKeys extracts the streams that follow the STREAMS option of XREAD and XREADGROUP.
+func streamKeys(args []interface{}) []interface{} {
+	for i := range args {
+		if strings.ToUpper(argText(args[i])) == "STREAMS" {
+			rest := args[i+1:]
+			return rest[:len(rest)/2]
+		}
+	}
+
+	return nil
+}
+
+func firstArgument(args []interface{}) []interface{} {
+	if len(args) == 0 {
+		return nil
+	}
+
+	return args[:1]
+}
+
+// subcommandKeys extracts the keys of a container command as described by its subcommand found first.
+func subcommandKeys(subcommands CommandTable) func([]interface{}) []interface{} {
+	return func(args []interface{}) []interface{} {
+		if len(args) == 0 {
+			return nil
+		}
+
+		info, ok := subcommands[strings.ToUpper(argText(args[0]))]
+		if !ok {
+			return nil
+		}
+
+		return info.Keys(args)
+	}
+}
+
+// Lookup returns the description of the specified command.
+// Unknown commands are described without keys rather than guessing where they are.
+// They are sent to any node and follow the redirections of a cluster, which also knows the commands of its modules once loaded with LoadCommands.
+func (table CommandTable) Lookup(name string) *CommandInfo {
+	if table == nil {
+		table = DefaultCommands
+	}
+
+	name = strings.ToUpper(name)
+	if info, ok := table[name]; ok {
+		return info
+	}
+
+	if info, ok := DefaultCommands[name]; ok {
+		return info
+	}
+
+	return &CommandInfo{
+		Name: name,
+	}
+}
+
+// Keys returns the keys found in the arguments of the command.
+func (info *CommandInfo) Keys(args []interface{}) (keys []interface{}) {
+	if info.movable != nil {
+		return info.movable(args)
+	}
+
+	if info.FirstKey <= 0 {
+		return
+	}
+
+	last := info.LastKey
+	if last < 0 {
+		last += len(args) + 1
+	}
+
+	step := info.Step
+	if step <= 0 {
+		step = 1
+	}
+
+	for i := info.FirstKey; i <= last && i <= len(args); i += step {
+		keys = append(keys, args[i-1])
+	}
+
+	return
+}
+
+// LoadCommands queries the Redis instance for the description of its commands.
+// The keys of commands with movable keys can't be described this way so they are still found with the built-in table.
+// The keys of container commands such as XGROUP or OBJECT are found with the description of their subcommands when given,
+// otherwise with the built-in table.
+func LoadCommands(ctx context.Context, s ContextSender) (table CommandTable, err error) {
+	request := NewRequest("COMMAND")
+	if err = request.SendContext(ctx, s); err != nil {
+		return
+	}
+
+	reply, err := request.Result(0)
+	if err != nil {
+		return
+	}
+
+	items, ok := reply.([]interface{})
+	if !ok {
+		err = fmt.Errorf("redis returned an invalid reply to COMMAND '%v'", reply)
+		return
+	}
+
+	table = make(CommandTable, len(items))
+	for _, item := range items {
+		if info := commandInfo(item); info != nil {
+			table[info.Name] = info
+		}
+	}
+
+	return
+}
+
+// commandInfo decodes the description of a command given by COMMAND or nil if it is invalid.
+func commandInfo(item interface{}) (info *CommandInfo) {
+	fields, ok := item.([]interface{})
+	if !ok || len(fields) < 6 {
+		return
+	}
+
+	info = &CommandInfo{
+		Name: strings.ToUpper(argText(fields[0])),
+	}
+
+	values := make([]int, 3)
+	for i := range values {
+		n, _ := fields[3+i].(int64)
+		values[i] = int(n)
+	}
+
+	info.FirstKey, info.LastKey, info.Step = values[0], values[1], values[2]
+
+	flags, _ := fields[2].([]interface{})
+	for _, flag := range flags {
+		switch argText(flag) {
+		case "readonly":
+			info.ReadOnly = true
+		case "write":
+			info.Write = true
+		case "blocking":
+			info.Blocking = true
+		case "movablekeys":
+			if known, ok := DefaultCommands[info.Name]; ok {
+				info.movable = known.movable
+			}
+		}
+	}
+
+	// Redis 7 lists the subcommands of container commands after the ACL categories, tips and key specifications
+	if len(fields) > 9 && info.FirstKey <= 0 && info.movable == nil {
+		subcommands := make(CommandTable)
+		items, _ := fields[9].([]interface{})
+		for _, item := range items {
+			if sub := commandInfo(item); sub != nil {
+				name := sub.Name
+				if i := strings.IndexByte(name, '|'); i >= 0 {
+					name = name[i+1:]
+				}
+
+				subcommands[name] = sub
+			}
+		}
+
+		if len(subcommands) != 0 {
+			info.movable = subcommandKeys(subcommands)
+		}
+	}
+
+	if known, ok := DefaultCommands[info.Name]; ok {
+		info.timeout = known.timeout
+		if info.FirstKey <= 0 && info.movable == nil {
+			info.FirstKey, info.LastKey, info.Step, info.movable = known.FirstKey, known.LastKey, known.Step, known.movable
+		}
+	}
+
+	return
+}
+
+// keys returns the keys of the command as found with the specified table.
+func (cmd *command) keys(table CommandTable) (keys [][]byte) {
+	for _, arg := range table.Lookup(cmd.name).Keys(cmd.args) {
+		key, ok := keyBytes(arg)
+		if !ok {
+			key = []byte(argText(arg))
+		}
+
+		keys = append(keys, key)
+	}
+
+	return
+}
@@ -0,0 +1,120 @@
+// Copyright (c) 2015 Datacratic. All rights reserved.
+
+package redis
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+type senderFunc func(context.Context, *Request) error
+
+func (f senderFunc) SendContext(ctx context.Context, request *Request) error {
+	return f(ctx, request)
+}
+
+func TestCommandKeys(t *testing.T) {
+	tests := []struct {
+		args []interface{}
+		keys []string
+	}{
+		{[]interface{}{"GET", "a"}, []string{"a"}},
+		{[]interface{}{"get", []byte("a")}, []string{"a"}},
+		{[]interface{}{"PING"}, nil},
+		{[]interface{}{"INFO", "replication"}, nil},
+		{[]interface{}{"MSET", "a", 1, "b", 2}, []string{"a", "b"}},
+		{[]interface{}{"BLPOP", "a", "b", 0}, []string{"a", "b"}},
+		{[]interface{}{"EVAL", "return 1", 2, "a", "b", "arg"}, []string{"a", "b"}},
+		{[]interface{}{"EVALSHA", "sha", 0, "arg"}, nil},
+		{[]interface{}{"XREAD", "COUNT", 1, "STREAMS", "s1", "s2", "0", "0"}, []string{"s1", "s2"}},
+		{[]interface{}{"ZUNIONSTORE", "dst", 2, "a", "b", "WEIGHTS", 1, 2}, []string{"dst", "a", "b"}},
+		{[]interface{}{"XGROUP", "CREATE", "s", "g", "$"}, []string{"s"}},
+		{[]interface{}{"MEMORY", "USAGE", "k", "SAMPLES", 5}, []string{"k"}},
+		{[]interface{}{"CUSTOM.CMD", "k", "v"}, nil},
+		{[]interface{}{"INCR", 42}, []string{"42"}},
+	}
+
+	for _, test := range tests {
+		request := NewRequest(test.args[0].(string), test.args[1:]...)
+		if keys := request.Keys(0); !reflect.DeepEqual(keys, test.keys) {
+			t.Errorf("unexpected keys '%q' instead of '%q' for '%v'", keys, test.keys, test.args)
+		}
+	}
+
+	if _, err := NewRequest("PING").Key(0); err == nil {
+		t.Fatal("PING has no key")
+	}
+
+	if slot := NewRequest("PING").slot(nil); slot != 0 {
+		t.Fatalf("unexpected slot %d", slot)
+	}
+}
+
+func TestLoadCommands(t *testing.T) {
+	s := senderFunc(func(ctx context.Context, request *Request) error {
+		request.commands[0].result = []interface{}{
+			[]interface{}{[]byte("get"), int64(2), []interface{}{"readonly", "fast"}, int64(1), int64(1), int64(1)},
+			[]interface{}{[]byte("eval"), int64(-3), []interface{}{"noscript", "movablekeys"}, int64(0), int64(0), int64(0)},
+			[]interface{}{[]byte("blpop"), int64(-3), []interface{}{"write", "blocking"}, int64(1), int64(-2), int64(1)},
+		}
+
+		return nil
+	})
+
+	table, err := LoadCommands(context.Background(), s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if info := table.Lookup("get"); !info.ReadOnly || info.Write || info.FirstKey != 1 {
+		t.Fatalf("unexpected info '%+v'", info)
+	}
+
+	if info := table.Lookup("BLPOP"); !info.Blocking || !info.Write || info.LastKey != -2 {
+		t.Fatalf("unexpected info '%+v'", info)
+	}
+
+	if keys := table.Lookup("EVAL").Keys([]interface{}{"return 1", 1, "a"}); !reflect.DeepEqual(keys, []interface{}{"a"}) {
+		t.Fatalf("unexpected keys '%v'", keys)
+	}
+}
+
+func TestLoadContainerCommands(t *testing.T) {
+	// Redis 7 describes the keys of container commands with their subcommands only
+	s := senderFunc(func(ctx context.Context, request *Request) error {
+		request.commands[0].result = []interface{}{
+			[]interface{}{[]byte("xgroup"), int64(-2), []interface{}{}, int64(0), int64(0), int64(0)},
+			[]interface{}{[]byte("object"), int64(-2), []interface{}{}, int64(0), int64(0), int64(0)},
+			[]interface{}{[]byte("ping"), int64(-1), []interface{}{"fast"}, int64(0), int64(0), int64(0)},
+			[]interface{}{[]byte("module.config"), int64(-2), []interface{}{}, int64(0), int64(0), int64(0),
+				[]interface{}{}, []interface{}{}, []interface{}{},
+				[]interface{}{
+					[]interface{}{[]byte("module.config|get"), int64(3), []interface{}{"readonly"}, int64(2), int64(2), int64(1)},
+					[]interface{}{[]byte("module.config|stats"), int64(2), []interface{}{}, int64(0), int64(0), int64(0)},
+				},
+			},
+		}
+
+		return nil
+	})
+
+	table, err := LoadCommands(context.Background(), s)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	request := NewRequest("XGROUP", "CREATE", "stream", "group", "$")
+	request.Add("OBJECT", "ENCODING", "key")
+	request.Add("PING")
+	request.Add("MODULE.CONFIG", "get", "config")
+	request.Add("MODULE.CONFIG", "STATS")
+	request.Add("MODULE.UNKNOWN", "key")
+
+	expected := [][][]byte{{[]byte("stream")}, {[]byte("key")}, nil, {[]byte("config")}, nil, nil}
+	for i := range expected {
+		if keys := request.commands[i].keys(table); !reflect.DeepEqual(keys, expected[i]) {
+			t.Errorf("unexpected keys '%q' instead of '%q'", keys, expected[i])
+		}
+	}
+}
@@ -3,10 +3,15 @@
 package redis
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"math/rand"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -22,20 +27,91 @@ var DefaultMaximumConnectionRetries = 8
 // DefaultRetryTimeout defines the duration multiplicatively increased to provide exponential backoff delay when connecting to the Redis database.
 var DefaultRetryTimeout = time.Second
 
+// DefaultMaximumRetryTimeout defines the maximum delay between two attempts to connect to the Redis database.
+var DefaultMaximumRetryTimeout = 30 * time.Second
+
+// State defines the state of a connection to the Redis database.
+type State int32
+
+const (
+	// StateDisconnected is the state of a connection that was never established.
+	StateDisconnected State = iota
+	// StateConnected is the state of a connection ready to send requests.
+	StateConnected
+	// StateReconnecting is the state of a connection being reestablished while requests wait.
+	StateReconnecting
+	// StateFailed is the state of a connection that failed to be reestablished and is being retried in the background.
+	// Requests fail immediately with ErrConnectionUnavailable in this state.
+	StateFailed
+	// StateClosed is the state of a connection that was closed.
+	StateClosed
+)
+
+func (state State) String() string {
+	switch state {
+	case StateDisconnected:
+		return "disconnected"
+	case StateConnected:
+		return "connected"
+	case StateReconnecting:
+		return "reconnecting"
+	case StateFailed:
+		return "failed"
+	case StateClosed:
+		return "closed"
+	}
+
+	return fmt.Sprintf("state(%d)", int32(state))
+}
+
 // Conn implements a client connection to the Redis database.
 type Conn struct {
 	MaximumConcurrentRequests int
 	MaximumPendingRequests    int
 	MaximumConnectionRetries  int
 	RetryTimeout              time.Duration
+	MaximumRetryTimeout       time.Duration
+	Options                   DialOptions
+
+	// MaximumIdleBlockingConnections limits the dedicated connections kept for blocking commands and transactions.
+	MaximumIdleBlockingConnections int
+
+	// Retry defines how requests failing with transient errors such as LOADING are retried.
+	// They are not retried when nil.
+	Retry *RetryPolicy
+
+	// MaximumTransactionRetries limits the number of times a transaction is retried when its watched keys changed.
+	MaximumTransactionRetries int
+
+	// OnStateChange is called from the background writer each time the state of the connection changes.
+	// It must not block nor send requests on the connection.
+	OnStateChange func(last, next State)
+
+	// OnPush is called from the background reader with the out-of-band messages received with the RESP3 protocol.
+	// Those messages are discarded when not set.
+	OnPush func(Push)
 
 	db  dialer
 	lua map[string]string
 
-	feed chan *Request
-	conn *net.Conn
-	once sync.Once
-	wg   sync.WaitGroup
+	state  int32
+	feed   chan *Request
+	resets chan struct{}
+	once   sync.Once
+	wg     sync.WaitGroup
+
+	// closing guards the feed which is closed once while requests may still be sent concurrently.
+	closing sync.RWMutex
+	closed  bool
+
+	// latency is the moving average of the duration of reads in nanoseconds.
+	// It is measured once with a PING when probing is set for connections that didn't serve reads yet.
+	latency int64
+	probing int32
+
+	// spare holds the idle connections dedicated to blocking commands.
+	mu    sync.Mutex
+	spare []*link
 }
 
 type dialerFunc func() (net.Conn, error)
@@ -48,6 +124,80 @@ type dialer interface {
 	dial() (net.Conn, error)
 }
 
+// link holds a network connection shared by the background writer and reader.
+type link struct {
+	fd      net.Conn
+	encoder *Encoder
+	decoder *Decoder
+	options *DialOptions
+
+	mu  sync.Mutex
+	err error
+}
+
+func (l *link) failure() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.err
+}
+
+// fail marks the connection as unusable and closes it which also aborts pending reads.
+func (l *link) fail(err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.err == nil {
+		l.err = err
+		l.fd.Close()
+	}
+}
+
+func (l *link) encode(request *Request) (err error) {
+	if err = l.failure(); err != nil {
+		return
+	}
+
+	if l.options.WriteTimeout > 0 {
+		l.fd.SetWriteDeadline(deadline(l.options.WriteTimeout))
+	}
+
+	if err = request.encode(l.encoder); err != nil {
+		l.fail(err)
+	}
+
+	return
+}
+
+func (l *link) decode(request *Request) {
+	if err := l.failure(); err != nil {
+		request.err = err
+		return
+	}
+
+	if l.options.ReadTimeout > 0 {
+		l.fd.SetReadDeadline(deadline(l.options.ReadTimeout))
+	}
+
+	// replies that follow a failed read can't be matched with their requests
+	if request.decode(l.decoder); l.decoder.failed != nil {
+		l.fail(l.decoder.failed)
+	}
+}
+
+// roundTrip sends the request and waits for its reply within the timeout, if any.
+// It is only used on dedicated connections whose read deadline isn't shared with other requests.
+func (l *link) roundTrip(request *Request, timeout time.Duration) (err error) {
+	if err = l.encode(request); err != nil {
+		return
+	}
+
+	l.fd.SetReadDeadline(deadline(timeout))
+	if request.decode(l.decoder); l.decoder.failed != nil {
+		l.fail(l.decoder.failed)
+	}
+
+	return request.err
+}
+
 func (conn *Conn) process() {
 	pending := conn.MaximumPendingRequests
 	if 0 == pending {
@@ -55,6 +205,7 @@ func (conn *Conn) process() {
 	}
 
 	conn.feed = make(chan *Request, pending)
+	conn.resets = make(chan struct{}, 1)
 
 	requests := conn.MaximumConcurrentRequests
 	if 0 == requests {
@@ -82,96 +233,117 @@ func (conn *Conn) process() {
 			retries = DefaultMaximumConnectionRetries
 		}
 
-		timeout := conn.RetryTimeout
-		if 0 == timeout {
-			timeout = DefaultRetryTimeout
-		}
-
-		var encoder *Encoder
-		var decoder *Decoder
+		// connections recovered in the background while in fail mode
+		recovered := make(chan *link)
+		stop := make(chan struct{})
 
 		// try to connect for the first time
-		fd, err := conn.connect()
+		l, err := conn.connect()
+		if err == nil {
+			conn.setState(StateConnected)
+		}
 
-		// when in fail state, all pending commands are purged
-		fail := false
+	loop:
+		for {
+			var c *Request
 
-		for cmd := range conn.feed {
-			if fail {
-				if fail = cmd != nil; fail {
-					cmd.err = err
-					close(cmd.done)
+			select {
+			case cmd, ok := <-conn.feed:
+				if !ok {
+					break loop
 				}
 
+				c = cmd
+			case l = <-recovered:
+				conn.setState(StateConnected)
+				continue
+			case <-conn.resets:
+				// replies of requests already sent are still read from the previous connection
+				if l != nil {
+					k := l
+					read <- func() {
+						k.fail(errReset)
+					}
+				}
+
+				l, err = nil, errReset
 				continue
 			}
 
-			c := cmd
-			n := 0
+			// drop requests that were cancelled while waiting in the queue
+			if c.ctx != nil && c.ctx.Err() != nil {
+				c.err = c.ctx.Err()
+				close(c.done)
+				continue
+			}
 
-			for n < retries {
-				// encode and send the request over the network
-				if fd != nil {
-					if encoder == nil {
-						encoder = NewEncoder(fd)
-					}
+			// when in fail mode, requests are purged until the connection is recovered
+			if conn.State() == StateFailed {
+				c.err = &UnavailableError{Err: err}
+				close(c.done)
+				continue
+			}
 
-					err = c.encode(encoder)
+			for n := 0; ; n++ {
+				// encode and send the request over the network
+				if l != nil {
+					err = l.encode(c)
 				}
 
-				// handle errors by reconnecting
-				if err != nil {
-					if fd != nil {
-						fd.Close()
-						encoder = nil
-						decoder = nil
-					}
+				if err == nil {
+					break
+				}
 
-					if n != 0 {
-						time.Sleep(time.Duration(int64(n) * int64(timeout)))
-						log.Println("retry connect", n)
-					}
+				l = nil
 
-					fd, err = conn.connect()
-					if err != nil {
-						log.Println("connection error:", err)
-					}
-					n++
-					c.err = err
-					continue
+				// enter fail mode and keep trying to reconnect in the background
+				if n == retries {
+					c.err = &UnavailableError{Err: err}
+					conn.setState(StateFailed)
+					go conn.recover(n, recovered, stop)
+					break
 				}
 
-				c.err = nil
-
-				if decoder == nil {
-					decoder = NewDecoder(fd)
+				// handle errors by reconnecting
+				conn.setState(StateReconnecting)
+				if n != 0 {
+					time.Sleep(conn.backoff(n))
+					log.Println("retry connect", n)
 				}
 
-				// enqueue the decoding of the response to the request
-				d := decoder
-				read <- func() {
-					c.decode(d)
-					close(c.done)
+				// give up on this request if the caller is gone
+				if c.ctx != nil && c.ctx.Err() != nil {
+					c.err = c.ctx.Err()
+					break
 				}
 
-				n = 0
-				break
+				if l, err = conn.connect(); err != nil {
+					log.Println("connection error:", err)
+				} else {
+					conn.setState(StateConnected)
+				}
 			}
 
-			// enter fail mode to purge pending requests
-			if n != 0 {
+			if l == nil {
 				close(c.done)
+				continue
+			}
 
-				fail = true
-				go func() {
-					conn.feed <- nil
-				}()
+			c.err = nil
 
-				panic("fail to reconnect")
-				break
+			// enqueue the decoding of the response to the request
+			k := l
+			read <- func() {
+				k.decode(c)
+				close(c.done)
 			}
 		}
 
+		close(stop)
+		if l != nil {
+			l.fail(io.ErrClosedPipe)
+		}
+
 		close(read)
 		wg.Wait()
 		conn.wg.Done()
@@ -180,6 +352,87 @@ func (conn *Conn) process() {
 	return
 }
 
+// errReset is the error of connections dropped on purpose to establish new ones.
+var errReset = errors.New("redis connection reset")
+
+// reconnect drops the current connections so that the next requests establish new ones e.g. after a failover.
+// Requests waiting to be sent aren't affected.
+func (conn *Conn) reconnect() {
+	conn.once.Do(conn.process)
+
+	select {
+	case conn.resets <- struct{}{}:
+	default:
+	}
+
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+
+	for _, l := range conn.spare {
+		l.fail(errReset)
+	}
+
+	conn.spare = nil
+}
+
+// recover keeps trying to connect with an increasing delay until it succeeds or the connection is closed.
+func (conn *Conn) recover(n int, recovered chan<- *link, stop <-chan struct{}) {
+	for ; ; n++ {
+		select {
+		case <-time.After(conn.backoff(n)):
+		case <-stop:
+			return
+		}
+
+		l, err := conn.connect()
+		if err != nil {
+			log.Println("connection error:", err)
+			continue
+		}
+
+		select {
+		case recovered <- l:
+		case <-stop:
+			l.fail(io.ErrClosedPipe)
+		}
+
+		return
+	}
+}
+
+// backoff returns the delay before the n-th attempt to reconnect.
+// The delay grows exponentially up to a maximum and is randomized to avoid reconnecting all clients at once.
+func (conn *Conn) backoff(n int) time.Duration {
+	timeout := conn.RetryTimeout
+	if 0 == timeout {
+		timeout = DefaultRetryTimeout
+	}
+
+	limit := conn.MaximumRetryTimeout
+	if 0 == limit {
+		limit = DefaultMaximumRetryTimeout
+	}
+
+	d := limit
+	if n < 32 && timeout<<uint(n-1) < limit {
+		d = timeout << uint(n-1)
+	}
+
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// State returns the current state of the connection.
+func (conn *Conn) State() State {
+	return State(atomic.LoadInt32(&conn.state))
+}
+
+func (conn *Conn) setState(state State) {
+	last := State(atomic.SwapInt32(&conn.state, int32(state)))
+	if last != state && conn.OnStateChange != nil {
+		conn.OnStateChange(last, state)
+	}
+}
+
 // LuaScript loads a script into the script cache.
 func (conn *Conn) LuaScript(code string) (id string, err error) {
 	result, err := conn.Do("SCRIPT", "LOAD", code)
@@ -202,14 +455,39 @@ func (conn *Conn) Close() {
 		return
 	}
 
-	close(conn.feed)
+	// never started?
+	conn.once.Do(func() {})
+
+	// wait for the requests being queued before closing the feed
+	conn.closing.Lock()
+	if conn.feed != nil && !conn.closed {
+		close(conn.feed)
+	}
+
+	conn.closed = true
+	conn.closing.Unlock()
 	conn.wg.Wait()
+
+	conn.setState(StateClosed)
+
+	conn.mu.Lock()
+	for _, l := range conn.spare {
+		l.fail(io.ErrClosedPipe)
+	}
+
+	conn.spare = nil
+	conn.mu.Unlock()
 }
 
 // Do executes the specified command (with optional arguments) to the Redis instance and waits to decode the reply.
 func (conn *Conn) Do(name string, args ...interface{}) (result interface{}, err error) {
+	return conn.DoContext(context.Background(), name, args...)
+}
+
+// DoContext executes the specified command (with optional arguments) to the Redis instance and waits to decode the reply or for the context to be done.
+func (conn *Conn) DoContext(ctx context.Context, name string, args ...interface{}) (result interface{}, err error) {
 	request := NewRequest(name, args...)
-	if err = conn.Send(request); err == nil {
+	if err = conn.SendContext(ctx, request); err == nil {
 		result = request.commands[len(request.commands)-1].result
 	}
 
@@ -218,68 +496,197 @@ func (conn *Conn) Do(name string, args ...interface{}) (result interface{}, err
 
 // Send sends the specified request to the Redis instance and waits for the reply.
 func (conn *Conn) Send(request *Request) error {
+	return conn.SendContext(context.Background(), request)
+}
+
+// SendContext sends the specified request to the Redis instance and waits for the reply or for the context to be done.
+// A request abandoned after being written still has its reply consumed in the background to keep the pipeline in sync.
+// Therefore, it must not be reused once the context is done.
+// Blocking commands such as BLPOP, XREAD BLOCK or WAIT are sent over a dedicated connection instead
+// with a read timeout derived from their own timeout.
+// Requests failing with transient errors are sent again according to the Retry policy, if any.
+func (conn *Conn) SendContext(ctx context.Context, request *Request) error {
+	return conn.Retry.do(ctx, request, conn.send)
+}
+
+func (conn *Conn) send(ctx context.Context, request *Request) error {
 	conn.once.Do(conn.process)
+	if conn.State() == StateClosed {
+		return ErrConnectionUnavailable
+	}
+
+	if timeout, ok := request.blocking(); ok {
+		return conn.sendBlocking(ctx, request, timeout)
+	}
+
+	request.ctx = ctx
 	request.done = make(chan struct{})
-	conn.feed <- request
-	<-request.done
-	return request.err
+
+	if err := conn.enqueue(ctx, request); err != nil {
+		return err
+	}
+
+	select {
+	case <-request.done:
+		return request.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
 
-func (conn *Conn) connect() (result net.Conn, err error) {
+// enqueue queues the request to be sent unless the connection is closed meanwhile.
+func (conn *Conn) enqueue(ctx context.Context, request *Request) error {
+	conn.closing.RLock()
+	defer conn.closing.RUnlock()
+
+	if conn.closed {
+		return ErrConnectionUnavailable
+	}
+
+	select {
+	case conn.feed <- request:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (conn *Conn) connect() (result *link, err error) {
 	c, err := conn.db.dial()
 	if err != nil {
 		return
 	}
 
-	// load lua scripts when needed
-	n := len(conn.lua)
-	if n != 0 {
-		ids := make([]string, 0, n)
+	defer func() {
+		if err != nil {
+			c.Close()
+		}
+	}()
 
-		// work directly on the stream to bypass everything
-		encoder := NewEncoder(c)
-		decoder := NewDecoder(c)
+	// bound the time spent preparing the connection
+	if conn.Options.ConnectTimeout > 0 {
+		c.SetDeadline(deadline(conn.Options.ConnectTimeout))
+		defer c.SetDeadline(time.Time{})
+	}
+
+	// authenticate, select the database and load lua scripts when needed
+	request, ids, err := conn.handshake()
+	if err != nil {
+		return
+	}
 
-		// send all scripts commands at once
-		for key, code := range conn.lua {
-			encoder.Encode("SCRIPT", "LOAD", code)
-			ids = append(ids, key)
+	if n := request.Len(); n != 0 {
+		// work directly on the stream to bypass everything
+		if err = request.encode(NewEncoder(c)); err != nil {
+			return
 		}
 
 		// wait for the result of each command
-		for i := 0; i < n; i++ {
-			var reply interface{}
-			reply, err = decoder.Decode()
-			if err != nil {
+		if err = request.decode(NewDecoder(c)); err != nil {
+			return
+		}
+
+		for i, id := range ids {
+			reply, _ := request.Result(n - len(ids) + i)
+			if text, _ := reply.([]byte); string(text) != id {
+				err = fmt.Errorf("script SHA1 doesn't match '%s' vs. '%s'", id, text)
 				return
 			}
+		}
+	}
 
-			id := string(reply.([]byte))
-			if id != ids[i] {
-				err = fmt.Errorf("script SHA1 doesn't match '%s' vs. '%s'", ids[i], id)
-				return
+	result = &link{
+		fd:      c,
+		encoder: conn.Options.newEncoder(c),
+		decoder: conn.Options.newDecoder(c),
+		options: &conn.Options,
+	}
+
+	// never mistake out-of-band messages for replies
+	result.decoder.push = conn.OnPush
+	if result.decoder.push == nil {
+		result.decoder.push = func(Push) {}
+	}
+
+	return
+}
+
+// handshake prepares the commands that must be replayed each time a connection is established.
+func (conn *Conn) handshake() (request *Request, ids []string, err error) {
+	options := &conn.Options
+	request = &Request{}
+
+	switch options.Protocol {
+	case 0:
+		if options.Password != "" {
+			if options.Username != "" {
+				request.Add("AUTH", options.Username, options.Password)
+			} else {
+				request.Add("AUTH", options.Password)
 			}
 		}
+
+		if options.ClientName != "" {
+			request.Add("CLIENT", "SETNAME", options.ClientName)
+		}
+	case 2, 3:
+		args := []interface{}{options.Protocol}
+		if options.Password != "" {
+			username := options.Username
+			if username == "" {
+				username = "default"
+			}
+
+			args = append(args, "AUTH", username, options.Password)
+		}
+
+		if options.ClientName != "" {
+			args = append(args, "SETNAME", options.ClientName)
+		}
+
+		request.Add("HELLO", args...)
+	default:
+		err = fmt.Errorf("unsupported protocol version %d", options.Protocol)
+		return
+	}
+
+	if options.Database != 0 {
+		request.Add("SELECT", options.Database)
+	}
+
+	if options.ReadOnly {
+		request.Add("READONLY")
+	}
+
+	for key, code := range conn.lua {
+		request.Add("SCRIPT", "LOAD", code)
+		ids = append(ids, key)
 	}
 
-	result = c
 	return
 }
 
 // Dial connects to a Redis database instance at the specified address on the named network.
 func Dial(network, address string) *Conn {
-	return &Conn{
-		db: dialerFunc(func() (net.Conn, error) {
-			return net.Dial(network, address)
-		}),
-	}
+	return DialWithOptions(network, address, DialOptions{})
 }
 
 // DialTimeout connects to a Redis database instance at the specified address on the named network with a timeout.
 func DialTimeout(network, address string, timeout time.Duration) *Conn {
-	return &Conn{
-		db: dialerFunc(func() (net.Conn, error) {
-			return net.DialTimeout(network, address, timeout)
-		}),
+	return DialWithOptions(network, address, DialOptions{
+		ConnectTimeout: timeout,
+	})
+}
+
+// DialWithOptions connects to a Redis database instance at the specified address on the named network with the specified options.
+func DialWithOptions(network, address string, options DialOptions) *Conn {
+	conn := &Conn{
+		Options: options,
 	}
+
+	conn.db = dialerFunc(func() (net.Conn, error) {
+		return conn.Options.dial(network, address)
+	})
+
+	return conn
 }
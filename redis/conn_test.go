@@ -3,10 +3,13 @@
 package redis
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net"
 	"reflect"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -29,6 +32,55 @@ func TestNoConnection(t *testing.T) {
 	}
 }
 
+func TestReconnect(t *testing.T) {
+	db := new(mockDB)
+	db.result.WriteString("+PONG\r\n")
+
+	var up int32
+	states := make(chan State, 16)
+	conn := &Conn{
+		MaximumConnectionRetries: 2,
+		RetryTimeout:             time.Millisecond,
+		MaximumRetryTimeout:      5 * time.Millisecond,
+		OnStateChange: func(last, next State) {
+			states <- next
+		},
+		db: dialerFunc(func() (net.Conn, error) {
+			if atomic.LoadInt32(&up) == 0 {
+				return nil, fmt.Errorf("no db")
+			}
+
+			return db.dial()
+		}),
+	}
+
+	defer conn.Close()
+
+	if result, err := conn.Do("PING"); !errors.Is(err, ErrConnectionUnavailable) || result != nil {
+		t.Fatal(err, result)
+	}
+
+	if state := conn.State(); state != StateFailed {
+		t.Fatalf("unexpected state '%s'", state)
+	}
+
+	// requests fail immediately while in fail mode
+	if result, err := conn.Do("PING"); !errors.Is(err, ErrConnectionUnavailable) || result != nil {
+		t.Fatal(err, result)
+	}
+
+	atomic.StoreInt32(&up, 1)
+	for state := range states {
+		if state == StateConnected {
+			break
+		}
+	}
+
+	if result, err := conn.Do("PING"); err != nil || result != "PONG" {
+		t.Fatal(err, result)
+	}
+}
+
 func TestConnectionDropped(t *testing.T) {
 	db := new(mockDB)
 	conn := &Conn{db: db}
@@ -55,6 +107,146 @@ func TestConnectionDropped(t *testing.T) {
 	}
 }
 
+func TestContext(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+
+	conn := &Conn{
+		db: dialerFunc(func() (net.Conn, error) {
+			return client, nil
+		}),
+	}
+
+	defer conn.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if result, err := conn.DoContext(ctx, "PING"); err != context.Canceled || result != nil {
+		t.Fatal(err, result)
+	}
+
+	// hold the reply of the first request until after its deadline
+	decoder := NewDecoder(server)
+	release := make(chan struct{})
+	go func() {
+		decoder.Decode()
+		<-release
+		server.Write([]byte("+first\r\n"))
+		decoder.Decode()
+		server.Write([]byte("+second\r\n"))
+	}()
+
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	if result, err := conn.DoContext(ctx, "PING"); err != context.DeadlineExceeded || result != nil {
+		t.Fatal(err, result)
+	}
+
+	close(release)
+
+	// the abandoned reply must not be delivered to the next request
+	if result, err := conn.Do("PING"); err != nil || result != "second" {
+		t.Fatal(err, result)
+	}
+}
+
+func TestReadTimeout(t *testing.T) {
+	servers := make(chan net.Conn, 2)
+	conn := &Conn{
+		Options: DialOptions{
+			ReadTimeout: 10 * time.Millisecond,
+		},
+		db: dialerFunc(func() (net.Conn, error) {
+			client, server := net.Pipe()
+			servers <- server
+			return client, nil
+		}),
+	}
+
+	defer conn.Close()
+
+	// the first server never replies
+	go func() {
+		server := <-servers
+		NewDecoder(server).Decode()
+	}()
+
+	if result, err := conn.Do("PING"); !IsTimeout(err) || result != nil {
+		t.Fatal(err, result)
+	}
+
+	// the connection is replaced after a timeout
+	go func() {
+		server := <-servers
+		NewDecoder(server).Decode()
+		server.Write([]byte("+PONG\r\n"))
+	}()
+
+	if result, err := conn.Do("PING"); err != nil || result != "PONG" {
+		t.Fatal(err, result)
+	}
+}
+
+func TestHandshake(t *testing.T) {
+	servers := make(chan net.Conn, 2)
+	conn := &Conn{
+		Options: DialOptions{
+			Username:   "user",
+			Password:   "secret",
+			Database:   3,
+			ClientName: "test",
+		},
+		db: dialerFunc(func() (net.Conn, error) {
+			client, server := net.Pipe()
+			servers <- server
+			return client, nil
+		}),
+	}
+
+	defer conn.Close()
+
+	expected := []interface{}{
+		[]interface{}{[]byte("AUTH"), []byte("user"), []byte("secret")},
+		[]interface{}{[]byte("CLIENT"), []byte("SETNAME"), []byte("test")},
+		[]interface{}{[]byte("SELECT"), []byte("3")},
+		[]interface{}{[]byte("PING")},
+	}
+
+	// accept the pipelined handshake then reply to a single PING before dropping the connection
+	serve := func() {
+		server := <-servers
+		decoder := NewDecoder(server)
+		for i := range expected {
+			result, err := decoder.Decode()
+			if err != nil || !reflect.DeepEqual(result, expected[i]) {
+				t.Errorf("unexpected command '%q' instead of '%q'", result, expected[i])
+			}
+
+			switch i {
+			case len(expected) - 2:
+				server.Write([]byte("+OK\r\n+OK\r\n+OK\r\n"))
+			case len(expected) - 1:
+				server.Write([]byte("+PONG\r\n"))
+			}
+		}
+
+		server.Close()
+	}
+
+	go serve()
+	if result, err := conn.Do("PING"); err != nil || result != "PONG" {
+		t.Fatal(err, result)
+	}
+
+	// the handshake is replayed on the new connection
+	go serve()
+	if result, err := conn.Do("PING"); err != nil || result != "PONG" {
+		t.Fatal(err, result)
+	}
+}
+
 var testCommands = []struct {
 	args     []interface{}
 	expected interface{}
@@ -215,3 +407,131 @@ func BenchmarkConn(b *testing.B) {
 	close(send)
 	wg.Wait()
 }
+
+func TestBlocking(t *testing.T) {
+	servers := make(chan net.Conn, 4)
+	conn := &Conn{
+		db: dialerFunc(func() (net.Conn, error) {
+			client, server := net.Pipe()
+			servers <- server
+			return client, nil
+		}),
+	}
+
+	defer conn.Close()
+
+	// the first connection serves the pipeline
+	go func() {
+		server := <-servers
+		decoder := NewDecoder(server)
+		for {
+			if _, err := decoder.Decode(); err != nil {
+				return
+			}
+
+			server.Write([]byte("+PONG\r\n"))
+		}
+	}()
+
+	if result, err := conn.Do("PING"); err != nil || result != "PONG" {
+		t.Fatal(err, result)
+	}
+
+	// the blocking command waits on its own connection
+	release := make(chan struct{})
+	go func() {
+		server := <-servers
+		NewDecoder(server).Decode()
+		<-release
+		server.Write([]byte("*2\r\n$4\r\nlist\r\n$4\r\nitem\r\n"))
+	}()
+
+	done := make(chan error)
+	go func() {
+		result, err := conn.Do("BLPOP", "list", 0)
+		if err == nil && !reflect.DeepEqual(result, []interface{}{[]byte("list"), []byte("item")}) {
+			err = fmt.Errorf("unexpected result '%q'", result)
+		}
+
+		done <- err
+	}()
+
+	if result, err := conn.Do("PING"); err != nil || result != "PONG" {
+		t.Fatal(err, result)
+	}
+
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	// cancelling the context aborts the blocking command
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	if result, err := conn.DoContext(ctx, "XREAD", "BLOCK", 0, "STREAMS", "events", "$"); err != context.DeadlineExceeded || result != nil {
+		t.Fatal(err, result)
+	}
+}
+
+func TestBlockingTimeout(t *testing.T) {
+	tests := []struct {
+		args     []interface{}
+		timeout  time.Duration
+		blocking bool
+	}{
+		{[]interface{}{"GET", "key"}, 0, false},
+		{[]interface{}{"BLPOP", "a", "b", 5}, 5 * time.Second, true},
+		{[]interface{}{"brpoplpush", "a", "b", "0.5"}, 500 * time.Millisecond, true},
+		{[]interface{}{"BZMPOP", 1.5, 1, "z", "MIN"}, 1500 * time.Millisecond, true},
+		{[]interface{}{"WAIT", 1, 100}, 100 * time.Millisecond, true},
+		{[]interface{}{"XREAD", "COUNT", 10, "BLOCK", []byte("250"), "STREAMS", "s", "$"}, 250 * time.Millisecond, true},
+		{[]interface{}{"XREAD", "STREAMS", "block", "$"}, 0, false},
+		{[]interface{}{"BLPOP", "a", 0}, 0, true},
+	}
+
+	for _, test := range tests {
+		request := NewRequest(test.args[0].(string), test.args[1:]...)
+		if timeout, ok := request.blocking(); timeout != test.timeout || ok != test.blocking {
+			t.Errorf("unexpected timeout '%s' (%t) for '%v'", timeout, ok, test.args)
+		}
+	}
+}
+
+func TestReconnectOnDemand(t *testing.T) {
+	servers := make(chan net.Conn, 2)
+	conn := &Conn{
+		db: dialerFunc(func() (net.Conn, error) {
+			client, server := net.Pipe()
+			servers <- server
+			return client, nil
+		}),
+	}
+
+	defer conn.Close()
+
+	serve := func(reply string) {
+		server := <-servers
+		decoder := NewDecoder(server)
+		for {
+			if _, err := decoder.Decode(); err != nil {
+				return
+			}
+
+			server.Write([]byte(reply))
+		}
+	}
+
+	go serve("+first\r\n")
+	if result, err := conn.Do("PING"); err != nil || result != "first" {
+		t.Fatal(err, result)
+	}
+
+	// the next requests go to a new connection
+	conn.reconnect()
+
+	go serve("+second\r\n")
+	if result, err := conn.Do("PING"); err != nil || result != "second" {
+		t.Fatal(err, result)
+	}
+}
@@ -0,0 +1,301 @@
+// Copyright (c) 2015 Datacratic. All rights reserved.
+
+package redis
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// DefaultMaximumScanFailures defines the number of times in a row scanning a node of a cluster can fail before giving up.
+var DefaultMaximumScanFailures = 4
+
+// Iterator walks the cursor of SCAN, HSCAN, SSCAN or ZSCAN to completion.
+// Elements are fetched in batches as Next is called.
+// As with SCAN itself, elements may be returned more than once, especially when nodes fail or slots move.
+type Iterator struct {
+	ctx   context.Context
+	name  string
+	key   string
+	args  []interface{}
+	pairs bool
+
+	// client is set when the nodes of a cluster are scanned and recovers from their failures.
+	client  *Client
+	cursors []*cursor
+
+	items []interface{}
+	item  []byte
+	value []byte
+	err   error
+}
+
+// cursor holds the position of the scan on one node along with the slots it served when the scan started.
+type cursor struct {
+	sender   ContextSender
+	node     *Conn
+	slots    []int
+	value    string
+	failures int
+}
+
+func newIterator(ctx context.Context, name, key string, sender ContextSender, match string, count int, kind string) (it *Iterator) {
+	it = &Iterator{
+		ctx:   ctx,
+		name:  name,
+		key:   key,
+		pairs: name == "HSCAN" || name == "ZSCAN",
+	}
+
+	if match != "" {
+		it.args = append(it.args, "MATCH", match)
+	}
+
+	if count > 0 {
+		it.args = append(it.args, "COUNT", count)
+	}
+
+	if kind != "" {
+		it.args = append(it.args, "TYPE", kind)
+	}
+
+	if sender != nil {
+		it.cursors = []*cursor{{sender: sender, value: "0"}}
+	}
+
+	return
+}
+
+// Next advances to the next element and returns false once the scan is complete or failed.
+func (it *Iterator) Next() bool {
+	for len(it.items) == 0 {
+		if it.err != nil || len(it.cursors) == 0 {
+			return false
+		}
+
+		it.fetch()
+	}
+
+	it.item, _ = scalar(it.items[0])
+	it.value, it.items = nil, it.items[1:]
+
+	// hashes and sorted sets return a value or a score with each element
+	if it.pairs && len(it.items) != 0 {
+		it.value, _ = scalar(it.items[0])
+		it.items = it.items[1:]
+	}
+
+	return true
+}
+
+// Key returns the current key, field or member.
+func (it *Iterator) Key() string {
+	return string(it.item)
+}
+
+// Value returns the value of the current field of a hash or the score of the current member of a sorted set.
+func (it *Iterator) Value() []byte {
+	return it.value
+}
+
+// Err returns the error that stopped the scan, if any.
+func (it *Iterator) Err() error {
+	return it.err
+}
+
+// fetch gets the next batch of elements from the first cursor.
+func (it *Iterator) fetch() {
+	c := it.cursors[0]
+
+	args := make([]interface{}, 0, 2+len(it.args))
+	if it.key != "" {
+		args = append(args, it.key)
+	}
+
+	args = append(args, c.value)
+	args = append(args, it.args...)
+
+	request := NewRequest(it.name, args...)
+	err := request.SendContext(it.ctx, c.sender)
+	if err == nil {
+		var reply interface{}
+		if reply, err = request.Result(0); err == nil {
+			err = it.parse(c, reply)
+		}
+	}
+
+	if err != nil {
+		it.recover(c, err)
+		return
+	}
+
+	c.failures = 0
+	if c.value == "0" {
+		it.cursors = it.cursors[1:]
+	}
+}
+
+func (it *Iterator) parse(c *cursor, reply interface{}) (err error) {
+	items, ok := reply.([]interface{})
+	if !ok || len(items) != 2 {
+		return fmt.Errorf("redis: unexpected %s reply '%v'", it.name, reply)
+	}
+
+	next, err := scalar(items[0])
+	if err != nil {
+		return
+	}
+
+	if it.items, ok = items[1].([]interface{}); !ok {
+		return fmt.Errorf("redis: unexpected %s elements '%v'", it.name, items[1])
+	}
+
+	c.value = string(next)
+	return
+}
+
+// recover decides whether the scan of a node can carry on after a failure.
+// The scan restarts on the new owners of the slots when they moved away from a failed node.
+func (it *Iterator) recover(c *cursor, err error) {
+	it.err = err
+	if it.client == nil || it.ctx.Err() != nil {
+		return
+	}
+
+	if !errors.Is(err, ErrConnectionUnavailable) && !IsTimeout(err) && !IsClusterDown(err) && c.node.State() != StateClosed {
+		return
+	}
+
+	limit := DefaultMaximumScanFailures
+	if c.failures++; c.failures >= limit {
+		return
+	}
+
+	it.client.refreshSoon()
+
+	select {
+	case <-time.After(c.node.backoff(c.failures)):
+	case <-it.ctx.Done():
+		return
+	}
+
+	state := it.client.state.Load().(*mapping)
+	if state.closed {
+		return
+	}
+
+	it.err = nil
+
+	cursors := cursorsOf(state, c.slots)
+	if len(cursors) == 1 && cursors[0].node == c.node {
+		return
+	}
+
+	it.cursors = append(cursors, it.cursors[1:]...)
+}
+
+// cursorsOf returns a cursor per node serving the specified slots.
+func cursorsOf(state *mapping, slots []int) (result []*cursor) {
+	index := make(map[*Conn]*cursor)
+	for _, slot := range slots {
+		node := state.slots[slot]
+		if node == nil {
+			continue
+		}
+
+		c := index[node]
+		if c == nil {
+			c = &cursor{
+				sender: node,
+				node:   node,
+				value:  "0",
+			}
+
+			index[node] = c
+			result = append(result, c)
+		}
+
+		c.slots = append(c.slots, slot)
+	}
+
+	return
+}
+
+// Scan iterates over the keys of the database matching the optional pattern and type.
+// Count is a hint of the number of keys fetched at once when not 0.
+func (conn *Conn) Scan(ctx context.Context, match string, count int, kind string) *Iterator {
+	return newIterator(ctx, "SCAN", "", conn, match, count, kind)
+}
+
+// HScan iterates over the fields of the hash stored at key and their values.
+func (conn *Conn) HScan(ctx context.Context, key, match string, count int) *Iterator {
+	return newIterator(ctx, "HSCAN", key, conn, match, count, "")
+}
+
+// SScan iterates over the members of the set stored at key.
+func (conn *Conn) SScan(ctx context.Context, key, match string, count int) *Iterator {
+	return newIterator(ctx, "SSCAN", key, conn, match, count, "")
+}
+
+// ZScan iterates over the members of the sorted set stored at key and their scores.
+func (conn *Conn) ZScan(ctx context.Context, key, match string, count int) *Iterator {
+	return newIterator(ctx, "ZSCAN", key, conn, match, count, "")
+}
+
+// Scan iterates over the keys matching the optional pattern and type.
+// On a cluster, every master is scanned one after the other and the scan carries on with the new owners of the slots of nodes that fail.
+func (client *Client) Scan(ctx context.Context, match string, count int, kind string) *Iterator {
+	it := newIterator(ctx, "SCAN", "", nil, match, count, kind)
+
+	state, err := client.discover(ctx)
+	if err != nil {
+		it.err = err
+		return it
+	}
+
+	slots := make([]int, len(state.slots))
+	for i := range slots {
+		slots[i] = i
+	}
+
+	it.client = client
+	it.cursors = cursorsOf(state, slots)
+	return it
+}
+
+// HScan iterates over the fields of the hash stored at key and their values on the node serving its slot.
+func (client *Client) HScan(ctx context.Context, key, match string, count int) *Iterator {
+	return newIterator(ctx, "HSCAN", key, client, match, count, "")
+}
+
+// SScan iterates over the members of the set stored at key on the node serving its slot.
+func (client *Client) SScan(ctx context.Context, key, match string, count int) *Iterator {
+	return newIterator(ctx, "SSCAN", key, client, match, count, "")
+}
+
+// ZScan iterates over the members of the sorted set stored at key and their scores on the node serving its slot.
+func (client *Client) ZScan(ctx context.Context, key, match string, count int) *Iterator {
+	return newIterator(ctx, "ZSCAN", key, client, match, count, "")
+}
+
+// discover returns the mapping of slots after checking if the first node is part of a cluster the client doesn't know about yet.
+func (client *Client) discover(ctx context.Context) (state *mapping, err error) {
+	state = client.current()
+	if state.shards {
+		return
+	}
+
+	reply, err := state.slots[0].DoContext(ctx, "INFO", "cluster")
+	if err != nil {
+		return
+	}
+
+	if text, _ := scalar(reply); bytes.Contains(text, []byte("cluster_enabled:1")) {
+		state, err = client.migrate()
+	}
+
+	return
+}
@@ -0,0 +1,110 @@
+// Copyright (c) 2015 Datacratic. All rights reserved.
+
+package redis
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestScan(t *testing.T) {
+	db := new(mockDB)
+	db.result.WriteString("*2\r\n$2\r\n17\r\n*2\r\n$1\r\na\r\n$1\r\nb\r\n")
+	db.result.WriteString("*2\r\n$1\r\n0\r\n*1\r\n$1\r\nc\r\n")
+
+	conn := &Conn{db: db}
+	defer conn.Close()
+
+	var keys []string
+	it := conn.Scan(context.Background(), "*", 10, "")
+	for it.Next() {
+		keys = append(keys, it.Key())
+	}
+
+	if err := it.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	if expected := []string{"a", "b", "c"}; !reflect.DeepEqual(keys, expected) {
+		t.Fatalf("unexpected keys '%v' instead of '%v'", keys, expected)
+	}
+}
+
+func TestScanPairs(t *testing.T) {
+	db := new(mockDB)
+	db.result.WriteString("*2\r\n$1\r\n0\r\n*4\r\n$1\r\na\r\n$1\r\n1\r\n$1\r\nb\r\n$1\r\n2\r\n")
+	db.result.WriteString("-WRONGTYPE Operation against a key holding the wrong kind of value\r\n")
+
+	conn := &Conn{db: db}
+	defer conn.Close()
+
+	fields := make(map[string]string)
+	it := conn.HScan(context.Background(), "hash", "", 0)
+	for it.Next() {
+		fields[it.Key()] = string(it.Value())
+	}
+
+	if err := it.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	if expected := map[string]string{"a": "1", "b": "2"}; !reflect.DeepEqual(fields, expected) {
+		t.Fatalf("unexpected fields '%v' instead of '%v'", fields, expected)
+	}
+
+	it = conn.SScan(context.Background(), "hash", "", 0)
+	if it.Next() || !IsWrongType(it.Err()) {
+		t.Fatal(it.Err())
+	}
+}
+
+func TestScanResumes(t *testing.T) {
+	client := &Client{}
+
+	db := new(mockDB)
+	db.result.WriteString("*2\r\n$1\r\n0\r\n*2\r\n$1\r\na\r\n$1\r\nb\r\n")
+
+	a := &Conn{RetryTimeout: time.Millisecond}
+	a.Close()
+	b := &Conn{db: db}
+
+	state := &mapping{
+		shards: true,
+		nodes: map[string]*Conn{
+			"tcp://127.0.0.1:7000": a,
+			"tcp://127.0.0.1:7001": b,
+		},
+	}
+
+	for i := range state.slots {
+		state.slots[i] = a
+	}
+
+	client.state.Store(state)
+	defer client.Close()
+
+	it := client.Scan(context.Background(), "", 0, "")
+
+	// the slots of the failed node move to the other one after the cursors were made
+	moved := *state
+	for i := range moved.slots {
+		moved.slots[i] = b
+	}
+
+	client.state.Store(&moved)
+
+	var keys []string
+	for it.Next() {
+		keys = append(keys, it.Key())
+	}
+
+	if err := it.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	if expected := []string{"a", "b"}; !reflect.DeepEqual(keys, expected) {
+		t.Fatalf("unexpected keys '%v' instead of '%v'", keys, expected)
+	}
+}
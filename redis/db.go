@@ -4,6 +4,7 @@ package redis
 
 import (
 	"bufio"
+	"crypto/tls"
 	"fmt"
 	"io/ioutil"
 	"log"
@@ -21,20 +22,33 @@ type DB struct {
 	dir string
 	ipc string
 	end chan struct{}
+
+	tls  *tls.Config
+	addr string
+
+	// data is the temporary directory of test instances holding their files and certificates.
+	data string
 }
 
 // New creates a local Redis database instance.
 // Communication is done via a unix socket.
 // Set "port" to "0" in the config to avoid conflicts with allocated ports when needed.
 func New(path string, config map[string]string) (result *DB, err error) {
+	return start(path, config, false)
+}
+
+// NewSentinel creates a local Redis Sentinel instance listening on the port given in the config.
+// The config is saved in a temporary directory since sentinels rewrite it.
+func NewSentinel(path string, config map[string]string) (result *DB, err error) {
+	return start(path, config, true)
+}
+
+func start(path string, config map[string]string, sentinel bool) (result *DB, err error) {
 	dir, err := ioutil.TempDir("", "redis")
 	if err != nil {
 		return
 	}
 
-	ipc := fmt.Sprintf("%s/redis-%d.socket", dir, rand.Uint32())
-	config["unixsocket"] = ipc
-
 	if path == "" {
 		path = os.Getenv("REDIS")
 		if path == "" {
@@ -42,12 +56,8 @@ func New(path string, config map[string]string) (result *DB, err error) {
 		}
 	}
 
-	cmd := exec.Command(path, "-")
-
 	db := &DB{
-		cmd: cmd,
 		dir: dir,
-		ipc: ipc,
 		end: make(chan struct{}),
 	}
 
@@ -55,6 +65,28 @@ func New(path string, config map[string]string) (result *DB, err error) {
 		db.Close()
 	}()
 
+	if sentinel {
+		db.addr = "127.0.0.1:" + config["port"]
+
+		name := fmt.Sprintf("%s/sentinel.conf", dir)
+		text := ""
+		for key, value := range config {
+			text += fmt.Sprintf("%s %s\n", key, value)
+		}
+
+		if err = ioutil.WriteFile(name, []byte(text), 0600); err != nil {
+			return
+		}
+
+		db.cmd = exec.Command(path, name, "--sentinel")
+	} else {
+		db.ipc = fmt.Sprintf("%s/redis-%d.socket", dir, rand.Uint32())
+		config["unixsocket"] = db.ipc
+		db.cmd = exec.Command(path, "-")
+	}
+
+	cmd := db.cmd
+
 	stderr, err := cmd.StderrPipe()
 	if err != nil {
 		return
@@ -74,27 +106,35 @@ func New(path string, config map[string]string) (result *DB, err error) {
 		close(end)
 	}()
 
-	stdin, err := cmd.StdinPipe()
-	if err != nil {
-		return
-	}
-
-	err = cmd.Start()
-	if err != nil {
-		return
-	}
+	if sentinel {
+		if err = cmd.Start(); err != nil {
+			return
+		}
+	} else {
+		stdin, e := cmd.StdinPipe()
+		if err = e; err != nil {
+			return
+		}
 
-	for key, value := range config {
-		_, err = fmt.Fprintf(stdin, "%s %s\n", key, value)
+		err = cmd.Start()
 		if err != nil {
 			return
 		}
-	}
 
-	stdin.Close()
+		for key, value := range config {
+			_, err = fmt.Fprintf(stdin, "%s %s\n", key, value)
+			if err != nil {
+				return
+			}
+		}
+
+		stdin.Close()
+	}
 
 	for i := 0; i != 100; i++ {
-		if _, err = os.Stat(ipc); err == nil {
+		var c net.Conn
+		if c, err = db.dial(); err == nil {
+			c.Close()
 			break
 		}
 
@@ -103,12 +143,13 @@ func New(path string, config map[string]string) (result *DB, err error) {
 
 	conn := db.Dial()
 
-	reply, err := conn.Do("QUIT")
+	reply, err := conn.Do("PING")
+	conn.Close()
 	if err != nil {
 		return
 	}
 
-	if reply != OK {
+	if reply != "PONG" {
 		err = fmt.Errorf("failed to start Redis instance")
 		return
 	}
@@ -130,10 +171,155 @@ func NewTestDB() (result *DB, err error) {
 	}
 
 	result, err = New("", config)
+	if err != nil {
+		os.RemoveAll(dir)
+		return
+	}
+
+	result.data = dir
+	return
+}
+
+// newTestTCPDB creates a temporary Redis database instance that also listens on a local port.
+func newTestTCPDB(config map[string]string) (result *DB, err error) {
+	dir, err := ioutil.TempDir("", "redis")
+	if err != nil {
+		return
+	}
+
+	defer func() {
+		if err != nil {
+			os.RemoveAll(dir)
+		}
+	}()
+
+	port, err := freePort()
+	if err != nil {
+		return
+	}
+
+	if config == nil {
+		config = make(map[string]string)
+	}
+
+	config["port"] = fmt.Sprintf("%d", port)
+	config["dir"] = dir
+
+	result, err = New("", config)
+	if err != nil {
+		return
+	}
+
+	result.data = dir
+	result.addr = fmt.Sprintf("127.0.0.1:%d", port)
 	return
 }
 
+// Topology defines a local Redis master with a replica both monitored by a Redis Sentinel.
+type Topology struct {
+	Name     string
+	Master   *DB
+	Replica  *DB
+	Sentinel *DB
+}
+
+// NewTestTopology creates a temporary master, replica and sentinel on local ports.
+// It returns once the sentinel knows about the replica.
+func NewTestTopology() (result *Topology, err error) {
+	topology := &Topology{
+		Name: "test",
+	}
+
+	defer func() {
+		topology.Close()
+	}()
+
+	if topology.Master, err = newTestTCPDB(nil); err != nil {
+		return
+	}
+
+	host, port, _ := net.SplitHostPort(topology.Master.addr)
+	topology.Replica, err = newTestTCPDB(map[string]string{
+		"replicaof": host + " " + port,
+	})
+
+	if err != nil {
+		return
+	}
+
+	sentinel, err := freePort()
+	if err != nil {
+		return
+	}
+
+	topology.Sentinel, err = NewSentinel("", map[string]string{
+		"port":             fmt.Sprintf("%d", sentinel),
+		"sentinel monitor": fmt.Sprintf("%s %s %s 1", topology.Name, host, port),
+	})
+
+	if err != nil {
+		return
+	}
+
+	conn := topology.Sentinel.Dial()
+	defer conn.Close()
+
+	// detect failures quickly
+	if _, err = conn.Do("SENTINEL", "SET", topology.Name, "down-after-milliseconds", 1000, "failover-timeout", 5000); err != nil {
+		return
+	}
+
+	for i := 0; ; i++ {
+		reply, e := conn.Do("SENTINEL", "replicas", topology.Name)
+		if items, ok := reply.([]interface{}); e == nil && ok && len(items) != 0 {
+			break
+		}
+
+		if i == 100 {
+			err = fmt.Errorf("sentinel didn't discover the replica")
+			return
+		}
+
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	result, topology = topology, nil
+	return
+}
+
+// Dial creates a new client that finds the master through the sentinel.
+func (topology *Topology) Dial() *Sentinel {
+	return &Sentinel{
+		Sentinels:  []string{"tcp://" + topology.Sentinel.addr},
+		MasterName: topology.Name,
+	}
+}
+
+// Failover asks the sentinel to promote the replica.
+func (topology *Topology) Failover() (err error) {
+	conn := topology.Sentinel.Dial()
+	defer conn.Close()
+
+	_, err = conn.Do("SENTINEL", "FAILOVER", topology.Name)
+	return
+}
+
+// Close tears down the sentinel, the replica and the master.
+func (topology *Topology) Close() {
+	if topology == nil {
+		return
+	}
+
+	topology.Sentinel.Close()
+	topology.Replica.Close()
+	topology.Master.Close()
+}
+
 func (db *DB) dial() (net.Conn, error) {
+	if db.ipc == "" {
+		return net.Dial("tcp", db.addr)
+	}
+
 	return net.Dial("unix", db.ipc)
 }
 
@@ -144,9 +330,19 @@ func (db *DB) Dial() *Conn {
 	}
 }
 
+// PubSub creates a subscriber that connects directly to the Redis database instance.
+func (db *DB) PubSub() *PubSub {
+	return db.Dial().PubSub()
+}
+
 // URL returns a network/address pair encoded as scheme://host where scheme is the network and host is the address.
 func (db *DB) URL() string {
-	return "unix://" + db.ipc
+	endpoint := &Endpoint{
+		Network: "unix",
+		Address: db.ipc,
+	}
+
+	return endpoint.String()
 }
 
 // Close terminates the Redis database instance and removes any temporary data that was created.
@@ -172,10 +368,25 @@ func (db *DB) Close() {
 		}
 	}
 
-	if db.dir != "" {
-		err := os.RemoveAll(db.dir)
-		if err != nil {
+	for _, dir := range []string{db.dir, db.data} {
+		if dir == "" {
+			continue
+		}
+
+		if err := os.RemoveAll(dir); err != nil {
 			panic(err)
 		}
 	}
 }
+
+// freePort returns a local TCP port that is currently unused.
+func freePort() (port int, err error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return
+	}
+
+	port = l.Addr().(*net.TCPAddr).Port
+	err = l.Close()
+	return
+}
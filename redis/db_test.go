@@ -82,3 +82,20 @@ func BenchmarkPipeline(b *testing.B) {
 		b.Fail()
 	}
 }
+
+func TestTLS(t *testing.T) {
+	db, err := newTestTLSDB()
+	if err != nil {
+		t.Skip("redis-server doesn't support TLS")
+		return
+	}
+
+	defer db.Close()
+
+	conn := db.dialTLS()
+	defer conn.Close()
+
+	if result, err := conn.Do("PING"); err != nil || result != "PONG" {
+		t.Fatal(err, result)
+	}
+}
@@ -8,16 +8,30 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"math/big"
 	"strconv"
 )
 
 // OK represents the +OK string returned by many Redis commands.
 var OK interface{} = "+OK"
 
+// Push represents an out-of-band message sent by the Redis instance with the RESP3 protocol.
+type Push []interface{}
+
 // Decoder implements the decoding part of the Redis serialization protocol.
+// Both RESP2 and RESP3 replies are supported.
+// RESP3 maps are decoded as map[interface{}]interface{} with string keys for strings, sets as slices,
+// doubles as float64, booleans as bool, big numbers as *big.Int and verbatim strings as []byte.
+// Errors nested in aggregates are returned as error elements.
 type Decoder struct {
 	// reader adds some buffering to the input.
 	reader *bufio.Reader
+
+	// failed keeps the first read error since the stream can't be resynchronized afterwards.
+	failed error
+
+	// push receives out-of-band messages when set instead of returning them as replies.
+	push func(Push)
 }
 
 // NewDecoder creates a RESP decoder from the specified reader source.
@@ -29,9 +43,24 @@ func NewDecoder(reader io.Reader) (result *Decoder) {
 	return
 }
 
+// NewDecoderSize creates a RESP decoder from the specified reader source with a buffer of at least the specified size.
+func NewDecoderSize(reader io.Reader, size int) (result *Decoder) {
+	result = &Decoder{
+		reader: bufio.NewReaderSize(reader, size),
+	}
+
+	return
+}
+
+func (decoder *Decoder) fail(err error) error {
+	decoder.failed = timeout("read", err)
+	return decoder.failed
+}
+
 func (decoder *Decoder) getLine() (result string, err error) {
 	line, err := decoder.reader.ReadString('\n')
 	if err != nil {
+		err = decoder.fail(err)
 		return
 	}
 
@@ -55,6 +84,54 @@ func (decoder *Decoder) getLine() (result string, err error) {
 	return
 }
 
+// getBulk reads the payload of a bulk string whose length is given in the line.
+func (decoder *Decoder) getBulk(line string) (result []byte, err error) {
+	n, err := strconv.ParseInt(line[1:], 10, 64)
+	if n < 0 || err != nil {
+		return
+	}
+
+	reply := make([]byte, n)
+
+	_, err = io.ReadFull(decoder.reader, reply)
+	if err != nil {
+		err = decoder.fail(err)
+		return
+	}
+
+	_, err = decoder.getLine()
+	if err != nil {
+		return
+	}
+
+	result = reply
+	return
+}
+
+// getItems reads the elements of an aggregate whose count is given in the line.
+func (decoder *Decoder) getItems(line string, scale int64) (result []interface{}, err error) {
+	n, err := strconv.ParseInt(line[1:], 10, 64)
+	if n < 0 || err != nil {
+		return
+	}
+
+	reply := make([]interface{}, n*scale)
+	for i := range reply {
+		reply[i], err = decoder.get()
+		if err != nil {
+			if decoder.failed != nil {
+				return
+			}
+
+			// errors nested in aggregates such as the reply of EXEC belong to their element
+			reply[i], err = err, nil
+		}
+	}
+
+	result = reply
+	return
+}
+
 func (decoder *Decoder) get() (result interface{}, err error) {
 	line, err := decoder.getLine()
 	if err != nil {
@@ -75,45 +152,92 @@ func (decoder *Decoder) get() (result interface{}, err error) {
 
 		result = line[1:]
 	case '-':
-		result, err = line[1:], fmt.Errorf("redis returned an error: %s", line[1:])
+		result, err = line[1:], parseError(line[1:])
 	case ':':
 		result, err = strconv.ParseInt(line[1:], 10, 64)
 	case '$':
-		var n int64
-		n, err = strconv.ParseInt(line[1:], 10, 64)
-		if n < 0 || err != nil {
+		var reply []byte
+		if reply, err = decoder.getBulk(line); reply != nil {
+			result = reply
+		}
+	case '*':
+		var reply []interface{}
+		if reply, err = decoder.getItems(line, 1); reply != nil {
+			result = reply
+		}
+	case '_':
+		result = nil
+	case '#':
+		switch line[1:] {
+		case "t":
+			result = true
+		case "f":
+			result = false
+		default:
+			err = fmt.Errorf("redis returned an invalid boolean '%s'", line)
+		}
+	case ',':
+		result, err = strconv.ParseFloat(line[1:], 64)
+	case '(':
+		n, ok := new(big.Int).SetString(line[1:], 10)
+		if !ok {
+			err = fmt.Errorf("redis returned an invalid big number '%s'", line)
 			return
 		}
 
-		reply := make([]byte, n)
+		result = n
+	case '=':
+		var reply []byte
+		if reply, err = decoder.getBulk(line); err == nil {
+			// skip the 3 bytes format and the colon
+			if len(reply) >= 4 {
+				reply = reply[4:]
+			}
 
-		_, err = io.ReadFull(decoder.reader, reply)
-		if err != nil {
-			return
+			result = reply
 		}
-
-		_, err = decoder.getLine()
-		if err != nil {
-			return
+	case '!':
+		var reply []byte
+		if reply, err = decoder.getBulk(line); err == nil {
+			result, err = string(reply), parseError(string(reply))
 		}
-
-		result = reply
-	case '*':
-		var n int64
-		n, err = strconv.ParseInt(line[1:], 10, 64)
-		if n < 0 || err != nil {
+	case '%':
+		var reply []interface{}
+		if reply, err = decoder.getItems(line, 2); err != nil {
 			return
 		}
 
-		reply := make([]interface{}, n)
-		for i := range reply {
-			reply[i], err = decoder.get()
-			if err != nil {
+		m := make(map[interface{}]interface{}, len(reply)/2)
+		for i := 0; i < len(reply); i += 2 {
+			key := reply[i]
+			if b, ok := key.([]byte); ok {
+				key = string(b)
+			}
+
+			switch key.(type) {
+			case []interface{}, map[interface{}]interface{}:
+				err = fmt.Errorf("redis returned a map with an aggregate key")
 				return
 			}
+
+			m[key] = reply[i+1]
+		}
+
+		result = m
+	case '~':
+		result, err = decoder.getItems(line, 1)
+	case '>':
+		var reply []interface{}
+		if reply, err = decoder.getItems(line, 1); err == nil {
+			result = Push(reply)
+		}
+	case '|':
+		// attributes are auxiliary data that precede the actual reply
+		if _, err = decoder.getItems(line, 2); err != nil {
+			return
 		}
 
-		result = reply
+		result, err = decoder.get()
 	default:
 		result, err = line, fmt.Errorf("redis returned '%s'", line)
 	}
@@ -123,8 +247,20 @@ func (decoder *Decoder) get() (result interface{}, err error) {
 
 // Decode unmarshal the reply of the Redis instance for a command that was sent.
 func (decoder *Decoder) Decode() (result interface{}, err error) {
-	result, err = decoder.get()
-	return
+	if err = decoder.failed; err != nil {
+		return
+	}
+
+	for {
+		result, err = decoder.get()
+
+		p, ok := result.(Push)
+		if !ok || err != nil || decoder.push == nil {
+			return
+		}
+
+		decoder.push(p)
+	}
 }
 
 // Unmarshal decodes the reply from the buffer.
@@ -0,0 +1,102 @@
+// Copyright (c) 2015 Datacratic. All rights reserved.
+
+package redis
+
+import (
+	"bytes"
+	"math"
+	"math/big"
+	"reflect"
+	"testing"
+)
+
+var testReplies = []struct {
+	data     string
+	expected interface{}
+}{
+	{"+OK\r\n", OK},
+	{":42\r\n", int64(42)},
+	{"$3\r\nfoo\r\n", []byte("foo")},
+	{"$-1\r\n", nil},
+	{"*2\r\n$1\r\na\r\n:1\r\n", []interface{}{[]byte("a"), int64(1)}},
+	{"_\r\n", nil},
+	{"#t\r\n", true},
+	{"#f\r\n", false},
+	{",3.1415\r\n", 3.1415},
+	{",inf\r\n", math.Inf(1)},
+	{"(3492890328409238509324850943850943825024385\r\n", func() *big.Int {
+		n, _ := new(big.Int).SetString("3492890328409238509324850943850943825024385", 10)
+		return n
+	}()},
+	{"=15\r\ntxt:Some string\r\n", []byte("Some string")},
+	{"%2\r\n$5\r\nfirst\r\n:1\r\n+second\r\n#t\r\n", map[interface{}]interface{}{"first": int64(1), "second": true}},
+	{"~2\r\n+a\r\n+b\r\n", []interface{}{"a", "b"}},
+	{"|1\r\n+key-popularity\r\n%1\r\n$1\r\na\r\n,0.1923\r\n:2039\r\n", int64(2039)},
+	{">2\r\n+message\r\n+hello\r\n", Push{"message", "hello"}},
+}
+
+func TestDecodeRESP3(t *testing.T) {
+	for _, item := range testReplies {
+		result, err := Unmarshal([]byte(item.data))
+		if err != nil {
+			t.Errorf("unexpected error '%s' for %q", err, item.data)
+			continue
+		}
+
+		if n, ok := item.expected.(*big.Int); ok {
+			if m, ok := result.(*big.Int); !ok || n.Cmp(m) != 0 {
+				t.Errorf("unexpected result '%v' instead of '%v'", result, n)
+			}
+
+			continue
+		}
+
+		if !reflect.DeepEqual(result, item.expected) {
+			t.Errorf("unexpected result '%#v' instead of '%#v'", result, item.expected)
+		}
+	}
+
+	if _, err := Unmarshal([]byte("!21\r\nSYNTAX invalid syntax\r\n")); ErrorKind(err) != "SYNTAX" {
+		t.Fatal(err)
+	}
+}
+
+func TestDecodePush(t *testing.T) {
+	var pushed []Push
+
+	decoder := NewDecoder(bytes.NewBufferString(">2\r\n+invalidate\r\n*1\r\n$3\r\nkey\r\n+PONG\r\n"))
+	decoder.push = func(p Push) {
+		pushed = append(pushed, p)
+	}
+
+	if result, err := decoder.Decode(); err != nil || result != "PONG" {
+		t.Fatal(err, result)
+	}
+
+	if len(pushed) != 1 || pushed[0][0] != "invalidate" {
+		t.Fatal(pushed)
+	}
+}
+
+func TestDecodeNestedError(t *testing.T) {
+	decoder := NewDecoder(bytes.NewBufferString("*2\r\n+OK\r\n-WRONGTYPE Operation against a key holding the wrong kind of value\r\n+PONG\r\n"))
+
+	result, err := decoder.Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	items := result.([]interface{})
+	if len(items) != 2 || items[0] != OK {
+		t.Fatalf("unexpected result '%v'", result)
+	}
+
+	if e, ok := items[1].(error); !ok || !IsWrongType(e) {
+		t.Fatalf("unexpected element '%v'", items[1])
+	}
+
+	// the stream stays in sync
+	if result, err := decoder.Decode(); err != nil || result != "PONG" {
+		t.Fatal(err, result)
+	}
+}
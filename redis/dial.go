@@ -0,0 +1,125 @@
+// Copyright (c) 2014 Datacratic. All rights reserved.
+
+package redis
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"time"
+)
+
+// DialOptions defines how connections to the Redis database are established and maintained.
+// Zero values keep the defaults of the net package and no deadlines are applied.
+type DialOptions struct {
+	// ConnectTimeout bounds the time taken to dial and to prepare a new connection.
+	ConnectTimeout time.Duration
+	// ReadTimeout bounds the time taken to receive each reply.
+	ReadTimeout time.Duration
+	// WriteTimeout bounds the time taken to send each request.
+	WriteTimeout time.Duration
+	// KeepAlive specifies the period between TCP keep-alive probes; negative disables them.
+	KeepAlive time.Duration
+	// DisableNoDelay enables Nagle's algorithm on TCP connections.
+	DisableNoDelay bool
+	// ReadBufferSize defines the size of the buffer used to decode replies.
+	ReadBufferSize int
+	// WriteBufferSize defines the size of the buffer used to encode requests.
+	WriteBufferSize int
+	// TLSConfig enables TLS when not nil.
+	// The server name is taken from the address of each node unless set explicitly.
+	TLSConfig *tls.Config
+
+	// Username authenticates the connection with an ACL user when a password is set.
+	Username string
+	// Password authenticates the connection with AUTH when not empty.
+	Password string
+	// Database selects the logical database of the connection when not 0.
+	Database int
+	// ClientName names the connection with CLIENT SETNAME when not empty.
+	ClientName string
+	// ReadOnly sends READONLY so that a replica of a cluster serves reads of the slots of its master.
+	ReadOnly bool
+	// Protocol negotiates the protocol version (2 or 3) with HELLO when not 0.
+	// Authentication and naming are then carried by the HELLO command itself.
+	// RESP2 is used by default.
+	Protocol int
+}
+
+func (options *DialOptions) dial(network, address string) (result net.Conn, err error) {
+	dialer := net.Dialer{
+		Timeout:   options.ConnectTimeout,
+		KeepAlive: options.KeepAlive,
+	}
+
+	result, err = dialer.Dial(network, address)
+	if err != nil {
+		return
+	}
+
+	if options.DisableNoDelay {
+		if c, ok := result.(*net.TCPConn); ok {
+			c.SetNoDelay(false)
+		}
+	}
+
+	if options.TLSConfig != nil {
+		result, err = options.handshake(result, address)
+	}
+
+	return
+}
+
+func (options *DialOptions) handshake(c net.Conn, address string) (result net.Conn, err error) {
+	config := options.TLSConfig
+	if config.ServerName == "" {
+		host, _, e := net.SplitHostPort(address)
+		if e != nil {
+			host = address
+		}
+
+		// verify the hostname of each node individually
+		config = config.Clone()
+		config.ServerName = host
+	}
+
+	ctx := context.Background()
+	if options.ConnectTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, options.ConnectTimeout)
+		defer cancel()
+	}
+
+	t := tls.Client(c, config)
+	if err = t.HandshakeContext(ctx); err != nil {
+		c.Close()
+		return
+	}
+
+	result = t
+	return
+}
+
+func (options *DialOptions) newEncoder(c net.Conn) *Encoder {
+	if options.WriteBufferSize > 0 {
+		return NewEncoderSize(c, options.WriteBufferSize)
+	}
+
+	return NewEncoder(c)
+}
+
+func (options *DialOptions) newDecoder(c net.Conn) *Decoder {
+	if options.ReadBufferSize > 0 {
+		return NewDecoderSize(c, options.ReadBufferSize)
+	}
+
+	return NewDecoder(c)
+}
+
+func deadline(d time.Duration) (t time.Time) {
+	if d > 0 {
+		t = time.Now().Add(d)
+	}
+
+	return
+}
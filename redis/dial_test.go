@@ -0,0 +1,96 @@
+// Copyright (c) 2015 Datacratic. All rights reserved.
+
+package redis
+
+import (
+	"crypto/tls"
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestDialTLS(t *testing.T) {
+	dir, err := ioutil.TempDir("", "redis")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	config, client, err := newTestCertificates(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := tls.LoadX509KeyPair(config["tls-cert-file"], config["tls-key-file"])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{cert},
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer l.Close()
+
+	// reply PONG to every command
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				decoder := NewDecoder(c)
+				for {
+					if _, err := decoder.Decode(); err != nil {
+						c.Close()
+						return
+					}
+
+					c.Write([]byte("+PONG\r\n"))
+				}
+			}()
+		}
+	}()
+
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+
+	test := func(address string, options DialOptions) (interface{}, error) {
+		client := &Client{
+			Address: []string{address},
+			Options: options,
+		}
+
+		defer client.Close()
+		return client.Do("PING")
+	}
+
+	options := DialOptions{
+		TLSConfig:      client,
+		ConnectTimeout: time.Second,
+	}
+
+	for _, host := range []string{"127.0.0.1", "localhost"} {
+		if result, err := test("rediss://"+host+":"+port, options); err != nil || result != "PONG" {
+			t.Fatal(host, err, result)
+		}
+	}
+
+	// the server certificate isn't trusted by default
+	untrusted := &DialOptions{
+		TLSConfig: &tls.Config{},
+	}
+
+	if c, err := untrusted.dial("tcp", "127.0.0.1:"+port); err == nil {
+		c.Close()
+		t.Fatal("expected certificate verification to fail")
+	}
+}
@@ -25,12 +25,26 @@ func NewEncoder(writer io.Writer) (result *Encoder) {
 		writer: bufio.NewWriter(writer),
 	}
 
-	// assume the end of the scratch buffer will always be correctly terminated
-	result.scratch[len(result.scratch)-2] = '\r'
-	result.scratch[len(result.scratch)-1] = '\n'
+	result.terminate()
+	return
+}
+
+// NewEncoderSize creates a RESP encoder to the specified writer source with a buffer of at least the specified size.
+func NewEncoderSize(writer io.Writer, size int) (result *Encoder) {
+	result = &Encoder{
+		writer: bufio.NewWriterSize(writer, size),
+	}
+
+	result.terminate()
 	return
 }
 
+func (encoder *Encoder) terminate() {
+	// assume the end of the scratch buffer will always be correctly terminated
+	encoder.scratch[len(encoder.scratch)-2] = '\r'
+	encoder.scratch[len(encoder.scratch)-1] = '\n'
+}
+
 func (encoder *Encoder) putLen(prefix byte, k int) (err error) {
 	i := len(encoder.scratch) - 3
 	for {
@@ -131,7 +145,7 @@ func (encoder *Encoder) Encode(command string, args ...interface{}) (err error)
 		return
 	}
 
-	err = encoder.writer.Flush()
+	err = timeout("write", encoder.writer.Flush())
 	return
 }
 
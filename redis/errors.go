@@ -0,0 +1,208 @@
+// Copyright (c) 2014 Datacratic. All rights reserved.
+
+package redis
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// Error is an error reply returned by the Redis instance.
+// Kind holds the error prefix (ERR, WRONGTYPE, NOSCRIPT, ...) when there is one.
+type Error struct {
+	Kind    string
+	Message string
+}
+
+func (e *Error) Error() string {
+	if e.Kind == "" {
+		return "redis returned an error: " + e.Message
+	}
+
+	return "redis returned an error: " + e.Kind + " " + e.Message
+}
+
+// MovedError is returned when the slot of the key is served by another node of the cluster.
+type MovedError struct {
+	Slot    int
+	Address string
+}
+
+func (e *MovedError) Error() string {
+	return e.Unwrap().Error()
+}
+
+// Unwrap returns the underlying error reply.
+func (e *MovedError) Unwrap() error {
+	return &Error{
+		Kind:    "MOVED",
+		Message: strconv.Itoa(e.Slot) + " " + e.Address,
+	}
+}
+
+// AskError is returned when the key of a slot being migrated must be requested from another node of the cluster.
+type AskError struct {
+	Slot    int
+	Address string
+}
+
+func (e *AskError) Error() string {
+	return e.Unwrap().Error()
+}
+
+// Unwrap returns the underlying error reply.
+func (e *AskError) Unwrap() error {
+	return &Error{
+		Kind:    "ASK",
+		Message: strconv.Itoa(e.Slot) + " " + e.Address,
+	}
+}
+
+// parseError creates the error matching the text of an error reply.
+func parseError(text string) error {
+	kind, message := "", text
+	if i := strings.IndexByte(text, ' '); i > 0 && strings.ToUpper(text[:i]) == text[:i] {
+		kind, message = text[:i], text[i+1:]
+	}
+
+	if kind == "MOVED" || kind == "ASK" {
+		fields := strings.Fields(message)
+		if len(fields) == 2 {
+			if slot, err := strconv.Atoi(fields[0]); err == nil {
+				if kind == "MOVED" {
+					return &MovedError{Slot: slot, Address: fields[1]}
+				}
+
+				return &AskError{Slot: slot, Address: fields[1]}
+			}
+		}
+	}
+
+	return &Error{
+		Kind:    kind,
+		Message: message,
+	}
+}
+
+// ErrorKind returns the prefix of the error reply or an empty string if the error isn't a reply from Redis.
+func ErrorKind(err error) string {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Kind
+	}
+
+	return ""
+}
+
+// IsNoScript reports whether the script requested with EVALSHA isn't in the cache.
+func IsNoScript(err error) bool {
+	return ErrorKind(err) == "NOSCRIPT"
+}
+
+// IsBusy reports whether the instance is busy running a script.
+func IsBusy(err error) bool {
+	return ErrorKind(err) == "BUSY"
+}
+
+// IsLoading reports whether the instance is still loading its dataset.
+func IsLoading(err error) bool {
+	return ErrorKind(err) == "LOADING"
+}
+
+// IsTryAgain reports whether the keys of a multi-key command are temporarily split during resharding.
+func IsTryAgain(err error) bool {
+	return ErrorKind(err) == "TRYAGAIN"
+}
+
+// IsClusterDown reports whether the cluster is unable to serve requests.
+func IsClusterDown(err error) bool {
+	return ErrorKind(err) == "CLUSTERDOWN"
+}
+
+// IsReadOnly reports whether a write was sent to a replica.
+func IsReadOnly(err error) bool {
+	return ErrorKind(err) == "READONLY"
+}
+
+// IsWrongType reports whether the command was applied to a key holding the wrong kind of value.
+func IsWrongType(err error) bool {
+	return ErrorKind(err) == "WRONGTYPE"
+}
+
+// ErrTxAborted is returned when a transaction kept being aborted because its watched keys changed.
+var ErrTxAborted = errors.New("redis transaction aborted")
+
+// ErrCrossSlot is returned when the keys of a transaction sent to a cluster don't belong to the same slot,
+// or when the keys of a request sent to a ring don't belong to the same node.
+var ErrCrossSlot = errors.New("redis keys belong to different slots")
+
+// ErrConnectionUnavailable is returned when requests can't be sent because the connection is down.
+var ErrConnectionUnavailable = errors.New("redis connection unavailable")
+
+// UnavailableError is returned when the connection to the Redis database couldn't be reestablished.
+// It matches ErrConnectionUnavailable with errors.Is.
+type UnavailableError struct {
+	Err error
+}
+
+func (e *UnavailableError) Error() string {
+	if e.Err == nil {
+		return ErrConnectionUnavailable.Error()
+	}
+
+	return ErrConnectionUnavailable.Error() + ": " + e.Err.Error()
+}
+
+// Is reports whether the target is ErrConnectionUnavailable.
+func (e *UnavailableError) Is(target error) bool {
+	return target == ErrConnectionUnavailable
+}
+
+// Unwrap returns the last connection error.
+func (e *UnavailableError) Unwrap() error {
+	return e.Err
+}
+
+// TimeoutError is returned when a request couldn't be written or its reply read within the configured deadlines.
+type TimeoutError struct {
+	Op  string
+	Err error
+}
+
+func (e *TimeoutError) Error() string {
+	return "redis " + e.Op + " timeout: " + e.Err.Error()
+}
+
+// Timeout implements the net.Error interface.
+func (e *TimeoutError) Timeout() bool {
+	return true
+}
+
+// Temporary implements the net.Error interface.
+func (e *TimeoutError) Temporary() bool {
+	return true
+}
+
+// Unwrap returns the underlying network error.
+func (e *TimeoutError) Unwrap() error {
+	return e.Err
+}
+
+// IsTimeout reports whether the error is caused by a read or write deadline being exceeded.
+func IsTimeout(err error) bool {
+	var e *TimeoutError
+	return errors.As(err, &e)
+}
+
+func timeout(op string, err error) error {
+	if e, ok := err.(net.Error); ok && e.Timeout() {
+		return &TimeoutError{
+			Op:  op,
+			Err: err,
+		}
+	}
+
+	return err
+}
@@ -0,0 +1,54 @@
+// Copyright (c) 2015 Datacratic. All rights reserved.
+
+package redis
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestErrors(t *testing.T) {
+	_, err := Unmarshal([]byte("-NOSCRIPT No matching script. Please use EVAL.\r\n"))
+	if !IsNoScript(err) || IsBusy(err) {
+		t.Fatal(err)
+	}
+
+	_, err = Unmarshal([]byte("-MOVED 3999 127.0.0.1:6381\r\n"))
+	var moved *MovedError
+	if !errors.As(err, &moved) || moved.Slot != 3999 || moved.Address != "127.0.0.1:6381" || ErrorKind(err) != "MOVED" {
+		t.Fatal(err)
+	}
+
+	_, err = Unmarshal([]byte("-ASK 3999 127.0.0.1:6381\r\n"))
+	var ask *AskError
+	if !errors.As(err, &ask) || ask.Slot != 3999 || ask.Address != "127.0.0.1:6381" {
+		t.Fatal(err)
+	}
+
+	_, err = Unmarshal([]byte("-Error without prefix\r\n"))
+	var e *Error
+	if !errors.As(err, &e) || e.Kind != "" || e.Message != "Error without prefix" {
+		t.Fatal(err)
+	}
+}
+
+func TestRequestErrors(t *testing.T) {
+	request := NewRequest("GET", "a")
+	request.Add("GET", "b")
+
+	// an error doesn't prevent the following replies from being consumed
+	buffer := bytes.NewBufferString("-WRONGTYPE Operation against a key holding the wrong kind of value\r\n$1\r\nb\r\n+PONG\r\n")
+	decoder := NewDecoder(buffer)
+	if err := request.decode(decoder); !IsWrongType(err) {
+		t.Fatal(err)
+	}
+
+	if result, err := request.Result(1); err != nil || string(result.([]byte)) != "b" {
+		t.Fatal(err, result)
+	}
+
+	if result, err := decoder.Decode(); err != nil || result != "PONG" {
+		t.Fatal(err, result)
+	}
+}
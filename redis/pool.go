@@ -1,6 +1,7 @@
 package redis
 
 import (
+	"context"
 	"fmt"
 	"sync"
 )
@@ -8,13 +9,19 @@ import (
 type Pool struct {
 	network     string
 	address     string
+	options     DialOptions
 	connections []*Conn
 	free        []*Conn
 	sync.Mutex
 }
 
 func NewPool(numConn int, network, address string) (*Pool, error) {
-	pool := Pool{network: network, address: address, connections: []*Conn{}, free: []*Conn{}}
+	return NewPoolWithOptions(numConn, network, address, DialOptions{})
+}
+
+// NewPoolWithOptions creates a pool whose connections are established with the specified options.
+func NewPoolWithOptions(numConn int, network, address string, options DialOptions) (*Pool, error) {
+	pool := Pool{network: network, address: address, options: options, connections: []*Conn{}, free: []*Conn{}}
 	for i := 0; i < numConn; i++ {
 		pool.Lock()
 		if err := pool.add(); err != nil {
@@ -25,17 +32,52 @@ func NewPool(numConn int, network, address string) (*Pool, error) {
 	return &pool, nil
 }
 
+// NewPoolURL creates a pool connected to the Redis database instance described by the URL.
+// The number of initial connections is given by the pool_size option.
+func NewPoolURL(text string) (*Pool, error) {
+	endpoint, err := ParseURL(text)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewPoolWithOptions(endpoint.PoolSize, endpoint.Network, endpoint.Address, endpoint.Options)
+}
+
 func (pool *Pool) Do(command string, args ...interface{}) (interface{}, error) {
+	return pool.DoContext(context.Background(), command, args...)
+}
 
-	if conn, err := pool.get(); err != nil {
+// DoContext executes the command on a free connection and waits for the reply or for the context to be done.
+func (pool *Pool) DoContext(ctx context.Context, command string, args ...interface{}) (interface{}, error) {
+	request := NewRequest(command, args...)
+	if err := pool.SendContext(ctx, request); err != nil {
 		return nil, err
+	}
+
+	return request.commands[len(request.commands)-1].result, nil
+}
+
+// Send sends the request on a free connection and waits for the reply.
+func (pool *Pool) Send(request *Request) error {
+	return pool.SendContext(context.Background(), request)
+}
+
+// SendContext sends the request on a free connection and waits for the reply or for the context to be done.
+func (pool *Pool) SendContext(ctx context.Context, request *Request) error {
+	if conn, err := pool.get(); err != nil {
+		return err
 	} else {
-		reply, err := conn.Do(command, args...)
+		err := conn.SendContext(ctx, request)
 		pool.release(conn)
-		return reply, err
+		return err
 	}
 }
 
+// PubSub creates a subscriber with its own connection to the Redis database instance of the pool.
+func (pool *Pool) PubSub() *PubSub {
+	return DialWithOptions(pool.network, pool.address, pool.options).PubSub()
+}
+
 func (pool *Pool) Close() {
 	for _, conn := range pool.connections {
 		conn.Close()
@@ -80,7 +122,7 @@ func (pool *Pool) release(conn *Conn) {
 }
 
 func (pool *Pool) add() error {
-	conn := Dial(pool.network, pool.address)
+	conn := DialWithOptions(pool.network, pool.address, pool.options)
 	pool.connections = append(pool.connections, conn)
 	pool.free = append(pool.free, conn)
 	return nil
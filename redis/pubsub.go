@@ -0,0 +1,402 @@
+// Copyright (c) 2015 Datacratic. All rights reserved.
+
+package redis
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// DefaultMessageBuffer defines the number of messages that can be queued on the channel of a PubSub before blocking.
+var DefaultMessageBuffer = 100
+
+// Message defines a message received by a PubSub.
+// Kind is either message, pmessage, pong or the confirmation of a (un)subscription.
+type Message struct {
+	Kind    string
+	Pattern string
+	Channel string
+	Data    []byte
+	Count   int64
+}
+
+// PubSub implements a subscriber to Redis channels on its own connection.
+// Subscriptions are restored automatically when the connection is reestablished.
+type PubSub struct {
+	// OnMessage is called from the background reader for each message when set.
+	// Otherwise, messages are delivered on the channel returned by Messages.
+	OnMessage func(*Message)
+
+	// conn is used as a template to establish connections.
+	conn *Conn
+
+	mu       sync.Mutex
+	link     *link
+	channels map[string]struct{}
+	patterns map[string]struct{}
+	shards   map[string]struct{}
+	closed   bool
+
+	// onError receives the error replies that don't affect the connection.
+	onError func(error)
+
+	once     sync.Once
+	messages chan *Message
+	quit     chan struct{}
+	done     chan struct{}
+}
+
+func newPubSub(conn *Conn) *PubSub {
+	return &PubSub{
+		conn: &Conn{
+			RetryTimeout:        conn.RetryTimeout,
+			MaximumRetryTimeout: conn.MaximumRetryTimeout,
+			Options:             conn.Options,
+			db:                  conn.db,
+		},
+		channels: make(map[string]struct{}),
+		patterns: make(map[string]struct{}),
+		shards:   make(map[string]struct{}),
+		messages: make(chan *Message, DefaultMessageBuffer),
+		quit:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// PubSub creates a subscriber that connects to the same Redis database instance.
+func (conn *Conn) PubSub() *PubSub {
+	return newPubSub(conn)
+}
+
+// Messages returns the channel on which messages are delivered when OnMessage isn't set.
+// The channel is closed once the PubSub is closed.
+func (ps *PubSub) Messages() <-chan *Message {
+	return ps.messages
+}
+
+// Subscribe subscribes to the specified channels.
+func (ps *PubSub) Subscribe(channels ...string) error {
+	return ps.update("SUBSCRIBE", ps.channels, true, channels)
+}
+
+// Unsubscribe unsubscribes from the specified channels or from all of them when none is given.
+func (ps *PubSub) Unsubscribe(channels ...string) error {
+	return ps.update("UNSUBSCRIBE", ps.channels, false, channels)
+}
+
+// PSubscribe subscribes to the channels matching the specified patterns.
+func (ps *PubSub) PSubscribe(patterns ...string) error {
+	return ps.update("PSUBSCRIBE", ps.patterns, true, patterns)
+}
+
+// PUnsubscribe unsubscribes from the specified patterns or from all of them when none is given.
+func (ps *PubSub) PUnsubscribe(patterns ...string) error {
+	return ps.update("PUNSUBSCRIBE", ps.patterns, false, patterns)
+}
+
+// SSubscribe subscribes to the specified shard channels.
+// Each channel must be served by the node this PubSub is connected to.
+func (ps *PubSub) SSubscribe(channels ...string) error {
+	return ps.updateShards("SSUBSCRIBE", true, channels)
+}
+
+// SUnsubscribe unsubscribes from the specified shard channels or from all of them when none is given.
+func (ps *PubSub) SUnsubscribe(channels ...string) error {
+	if len(channels) == 0 {
+		ps.mu.Lock()
+		for channel := range ps.shards {
+			channels = append(channels, channel)
+		}
+
+		ps.mu.Unlock()
+	}
+
+	return ps.updateShards("SUNSUBSCRIBE", false, channels)
+}
+
+// updateShards sends one command per channel since channels of a single command must share the same slot.
+func (ps *PubSub) updateShards(name string, add bool, channels []string) (err error) {
+	for _, channel := range channels {
+		if err = ps.update(name, ps.shards, add, []string{channel}); err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// Ping sends a PING whose reply is delivered as a message of kind pong.
+func (ps *PubSub) Ping() error {
+	ps.once.Do(ps.start)
+
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	if ps.link == nil {
+		return ErrConnectionUnavailable
+	}
+
+	return ps.send("PING", nil)
+}
+
+// Close unsubscribes from everything and tears down the connection.
+func (ps *PubSub) Close() {
+	ps.mu.Lock()
+	if ps.closed {
+		ps.mu.Unlock()
+		return
+	}
+
+	ps.closed = true
+	if ps.link != nil {
+		ps.link.fail(ErrConnectionUnavailable)
+	}
+
+	ps.mu.Unlock()
+
+	close(ps.quit)
+
+	// never started?
+	ps.once.Do(func() {
+		close(ps.messages)
+		close(ps.done)
+	})
+
+	<-ps.done
+}
+
+func (ps *PubSub) update(name string, set map[string]struct{}, add bool, names []string) error {
+	ps.once.Do(ps.start)
+
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	if ps.closed {
+		return ErrConnectionUnavailable
+	}
+
+	for _, item := range names {
+		if add {
+			set[item] = struct{}{}
+		} else {
+			delete(set, item)
+		}
+	}
+
+	// unsubscribing without names removes every subscription of that kind
+	if !add && len(names) == 0 {
+		for item := range set {
+			delete(set, item)
+		}
+	}
+
+	// subscriptions are restored when connected
+	if ps.link == nil {
+		return nil
+	}
+
+	return ps.send(name, names)
+}
+
+// send writes a command on the current connection; the lock must be held.
+func (ps *PubSub) send(name string, names []string) error {
+	args := make([]interface{}, len(names))
+	for i := range names {
+		args[i] = names[i]
+	}
+
+	request := NewRequest(name, args...)
+	return ps.link.encode(request)
+}
+
+func (ps *PubSub) start() {
+	go ps.run()
+}
+
+func (ps *PubSub) run() {
+	defer close(ps.done)
+	defer close(ps.messages)
+
+	for n := 0; ; n++ {
+		if n != 0 {
+			select {
+			case <-time.After(ps.conn.backoff(n)):
+			case <-ps.quit:
+				return
+			}
+		}
+
+		l, err := ps.connect()
+		if err != nil {
+			log.Println("connection error:", err)
+			continue
+		}
+
+		if l == nil {
+			return
+		}
+
+		n = 0
+		for {
+			var reply interface{}
+			if reply, err = l.decoder.Decode(); err != nil {
+				// error replies leave the connection usable
+				if l.decoder.failed == nil {
+					if ps.onError != nil {
+						ps.onError(err)
+					}
+
+					continue
+				}
+
+				break
+			}
+
+			ps.deliver(reply)
+		}
+
+		l.fail(err)
+
+		ps.mu.Lock()
+		ps.link = nil
+		closed := ps.closed
+		ps.mu.Unlock()
+
+		if closed {
+			return
+		}
+	}
+}
+
+// connect establishes a new connection and restores the subscriptions.
+func (ps *PubSub) connect() (result *link, err error) {
+	l, err := ps.conn.connect()
+	if err != nil {
+		return
+	}
+
+	// messages are read as replies
+	l.decoder.push = nil
+
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	if ps.closed {
+		l.fail(ErrConnectionUnavailable)
+		return
+	}
+
+	ps.link = l
+
+	for _, item := range []struct {
+		name string
+		set  map[string]struct{}
+	}{
+		{"SUBSCRIBE", ps.channels},
+		{"PSUBSCRIBE", ps.patterns},
+	} {
+		if len(item.set) == 0 {
+			continue
+		}
+
+		names := make([]string, 0, len(item.set))
+		for name := range item.set {
+			names = append(names, name)
+		}
+
+		if err = ps.send(item.name, names); err != nil {
+			break
+		}
+	}
+
+	for channel := range ps.shards {
+		if err != nil {
+			break
+		}
+
+		err = ps.send("SSUBSCRIBE", []string{channel})
+	}
+
+	if err != nil {
+		ps.link = nil
+		l.fail(err)
+		return
+	}
+
+	result = l
+	return
+}
+
+func (ps *PubSub) deliver(reply interface{}) {
+	var items []interface{}
+	switch r := reply.(type) {
+	case Push:
+		items = r
+	case []interface{}:
+		items = r
+	default:
+		return
+	}
+
+	message := parseMessage(items)
+	if message == nil {
+		return
+	}
+
+	if ps.OnMessage != nil {
+		ps.OnMessage(message)
+		return
+	}
+
+	select {
+	case ps.messages <- message:
+	case <-ps.quit:
+	}
+}
+
+func parseMessage(items []interface{}) (result *Message) {
+	text := func(i int) string {
+		if i >= len(items) {
+			return ""
+		}
+
+		b, _ := scalar(items[i])
+		return string(b)
+	}
+
+	data := func(i int) []byte {
+		if i >= len(items) {
+			return nil
+		}
+
+		b, _ := items[i].([]byte)
+		return b
+	}
+
+	result = &Message{
+		Kind: text(0),
+	}
+
+	switch result.Kind {
+	case "message", "smessage":
+		result.Channel = text(1)
+		result.Data = data(2)
+	case "pmessage":
+		result.Pattern = text(1)
+		result.Channel = text(2)
+		result.Data = data(3)
+	case "pong":
+		result.Data = data(1)
+	case "subscribe", "unsubscribe", "ssubscribe", "sunsubscribe":
+		result.Channel = text(1)
+		result.Count, _ = items[len(items)-1].(int64)
+	case "psubscribe", "punsubscribe":
+		result.Pattern = text(1)
+		result.Count, _ = items[len(items)-1].(int64)
+	default:
+		result = nil
+	}
+
+	return
+}
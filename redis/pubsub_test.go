@@ -0,0 +1,110 @@
+// Copyright (c) 2015 Datacratic. All rights reserved.
+
+package redis
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestPubSub(t *testing.T) {
+	servers := make(chan net.Conn, 2)
+	conn := &Conn{
+		RetryTimeout: time.Millisecond,
+		db: dialerFunc(func() (net.Conn, error) {
+			client, server := net.Pipe()
+			servers <- server
+			return client, nil
+		}),
+	}
+
+	ps := conn.PubSub()
+	defer ps.Close()
+
+	// confirm the subscription then publish a message before dropping the connection
+	serve := func(text string) {
+		server := <-servers
+		result, err := NewDecoder(server).Decode()
+		expected := []interface{}{[]byte("SUBSCRIBE"), []byte("news")}
+		if err != nil || !reflect.DeepEqual(result, expected) {
+			t.Errorf("unexpected command '%q' instead of '%q'", result, expected)
+		}
+
+		server.Write([]byte("*3\r\n$9\r\nsubscribe\r\n$4\r\nnews\r\n:1\r\n"))
+		fmt.Fprintf(server, "*3\r\n$7\r\nmessage\r\n$4\r\nnews\r\n$%d\r\n%s\r\n", len(text), text)
+		server.Close()
+	}
+
+	go serve("hello")
+	if err := ps.Subscribe("news"); err != nil {
+		t.Fatal(err)
+	}
+
+	expect := func(kind, data string) {
+		select {
+		case m := <-ps.Messages():
+			if m.Kind != kind || m.Channel != "news" || string(m.Data) != data {
+				t.Fatalf("unexpected message '%+v'", m)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("timeout waiting for message")
+		}
+	}
+
+	expect("subscribe", "")
+	expect("message", "hello")
+
+	// the subscription is restored on the new connection
+	go serve("world")
+	expect("subscribe", "")
+	expect("message", "world")
+}
+
+func TestUnsubscribeAll(t *testing.T) {
+	servers := make(chan net.Conn, 2)
+	conn := &Conn{
+		RetryTimeout: time.Millisecond,
+		db: dialerFunc(func() (net.Conn, error) {
+			client, server := net.Pipe()
+			servers <- server
+			return client, nil
+		}),
+	}
+
+	ps := conn.PubSub()
+	defer ps.Close()
+
+	go func() {
+		server := <-servers
+		decoder := NewDecoder(server)
+		for {
+			if _, err := decoder.Decode(); err != nil {
+				return
+			}
+		}
+	}()
+
+	if err := ps.Subscribe("a", "b"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ps.PSubscribe("c*"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ps.Unsubscribe(); err != nil {
+		t.Fatal(err)
+	}
+
+	// nothing but the patterns would be restored on a new connection
+	ps.mu.Lock()
+	channels, patterns := len(ps.channels), len(ps.patterns)
+	ps.mu.Unlock()
+
+	if channels != 0 || patterns != 1 {
+		t.Fatalf("unexpected subscriptions to %d channels and %d patterns", channels, patterns)
+	}
+}
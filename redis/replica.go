@@ -0,0 +1,128 @@
+// Copyright (c) 2015 Datacratic. All rights reserved.
+
+package redis
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync/atomic"
+	"time"
+)
+
+// ReadPolicy defines which nodes of a cluster serve the read-only requests.
+type ReadPolicy int
+
+const (
+	// ReadFromMaster sends every request to the master of the slot.
+	ReadFromMaster ReadPolicy = iota
+	// ReadFromReplica sends read-only requests to a random replica of the slot when there is one.
+	ReadFromReplica
+	// ReadFromAny sends read-only requests to a random node among the master and the replicas of the slot.
+	ReadFromAny
+	// ReadFromNearest sends read-only requests to the node of the slot with the lowest observed latency.
+	// Replicas are only considered once their latency was measured in the background.
+	ReadFromNearest
+)
+
+// failurePenalty is the latency observed for a node that failed to serve a read.
+const failurePenalty = time.Second
+
+// readOnly reports whether all the commands of the request only read data.
+func (request *Request) readOnly(table CommandTable) bool {
+	for i := range request.commands {
+		if !table.Lookup(request.commands[i].name).ReadOnly {
+			return false
+		}
+	}
+
+	return len(request.commands) != 0
+}
+
+// reader picks the node serving a read-only request for a slot according to the read policy.
+func (client *Client) reader(state *mapping, master *Conn) *Conn {
+	replicas := state.replicas[master]
+	if len(replicas) == 0 {
+		return master
+	}
+
+	switch client.ReadFrom {
+	case ReadFromReplica:
+		return replicas[rand.Intn(len(replicas))]
+	case ReadFromAny:
+		if i := rand.Intn(len(replicas) + 1); i < len(replicas) {
+			return replicas[i]
+		}
+	case ReadFromNearest:
+		// nodes without a measure are the furthest
+		best, lowest := master, atomic.LoadInt64(&master.latency)
+		for _, node := range replicas {
+			latency := atomic.LoadInt64(&node.latency)
+			if latency == 0 {
+				node.probe()
+				continue
+			}
+
+			if lowest == 0 || latency < lowest {
+				best, lowest = node, latency
+			}
+		}
+
+		return best
+	}
+
+	return master
+}
+
+// read sends a read-only request to the specified node while observing its latency.
+// It reports whether the request must be sent to the master instead.
+func read(ctx context.Context, node *Conn, request *Request) (fallback bool, err error) {
+	start := time.Now()
+	err = node.SendContext(ctx, request)
+	if err == nil || ctx.Err() != nil {
+		node.observe(time.Since(start))
+		return
+	}
+
+	var e *Error
+	switch {
+	case request.redirect, !errors.As(err, &e):
+		fallback = true
+	case e.Kind == "LOADING", e.Kind == "MASTERDOWN", e.Kind == "CLUSTERDOWN", e.Kind == "TRYAGAIN":
+		fallback = true
+	}
+
+	if fallback {
+		node.observe(failurePenalty)
+	}
+
+	return
+}
+
+// probe measures the latency of the connection with a PING in the background unless already done.
+func (conn *Conn) probe() {
+	if !atomic.CompareAndSwapInt32(&conn.probing, 0, 1) {
+		return
+	}
+
+	go func() {
+		start := time.Now()
+		if _, err := conn.Do("PING"); err != nil {
+			conn.observe(failurePenalty)
+			return
+		}
+
+		conn.observe(time.Since(start))
+	}()
+}
+
+// observe updates the moving average of the latency of the connection.
+func (conn *Conn) observe(d time.Duration) {
+	last := atomic.LoadInt64(&conn.latency)
+	next := int64(d)
+	if last != 0 {
+		next = last + (next-last)/8
+	}
+
+	atomic.StoreInt64(&conn.latency, next)
+}
@@ -0,0 +1,132 @@
+// Copyright (c) 2015 Datacratic. All rights reserved.
+
+package redis
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestReadOnly(t *testing.T) {
+	request := NewRequest("GET", "a")
+	request.Add("HGETALL", "b")
+	if !request.readOnly(nil) {
+		t.Fatal("GET and HGETALL only read")
+	}
+
+	request.Add("SET", "a", 1)
+	if request.readOnly(nil) {
+		t.Fatal("SET writes")
+	}
+}
+
+func TestReader(t *testing.T) {
+	node := func() *Conn {
+		db := new(mockDB)
+		db.result.WriteString("+PONG\r\n")
+		return &Conn{db: db}
+	}
+
+	master := node()
+	replicas := []*Conn{node(), node()}
+	defer func() {
+		for _, conn := range append(replicas, master) {
+			conn.Close()
+		}
+	}()
+
+	state := &mapping{
+		replicas: map[*Conn][]*Conn{
+			master: replicas,
+		},
+	}
+
+	client := &Client{}
+	if node := client.reader(state, master); node != master {
+		t.Fatal("reads must go to the master by default")
+	}
+
+	client.ReadFrom = ReadFromReplica
+	for i := 0; i < 10; i++ {
+		if node := client.reader(state, master); node == master {
+			t.Fatal("reads must go to a replica")
+		}
+	}
+
+	// replicas that were never measured are probed before serving reads
+	client.ReadFrom = ReadFromNearest
+	master.observe(time.Millisecond)
+	if node := client.reader(state, master); node != master {
+		t.Fatal("reads must go to the master until replicas are measured")
+	}
+
+	for _, replica := range replicas {
+		for deadline := time.Now().Add(time.Second); atomic.LoadInt64(&replica.latency) == 0; time.Sleep(time.Millisecond) {
+			if time.Now().After(deadline) {
+				t.Fatal("replica wasn't probed")
+			}
+		}
+	}
+
+	atomic.StoreInt64(&replicas[0].latency, int64(time.Second))
+	atomic.StoreInt64(&replicas[1].latency, int64(time.Microsecond))
+	if node := client.reader(state, master); node != replicas[1] {
+		t.Fatal("reads must go to the nearest node")
+	}
+
+	// nodes without replicas serve their own reads
+	other := new(Conn)
+	if node := client.reader(state, other); node != other {
+		t.Fatal("reads must go to the master without replicas")
+	}
+}
+
+func TestReadFallback(t *testing.T) {
+	servers := make(chan net.Conn, 1)
+	replica := &Conn{
+		Options: DialOptions{
+			ReadOnly: true,
+		},
+		db: dialerFunc(func() (net.Conn, error) {
+			client, server := net.Pipe()
+			servers <- server
+			return client, nil
+		}),
+	}
+
+	defer replica.Close()
+
+	go func() {
+		server := <-servers
+		decoder := NewDecoder(server)
+
+		// the handshake marks the connection as read-only
+		if result, _ := decoder.Decode(); len(result.([]interface{})) != 1 || string(result.([]interface{})[0].([]byte)) != "READONLY" {
+			t.Errorf("unexpected handshake '%q'", result)
+		}
+
+		server.Write([]byte("+OK\r\n"))
+		for _, reply := range []string{"$1\r\n1\r\n", "-WRONGTYPE Operation against a key holding the wrong kind of value\r\n", "-LOADING Redis is loading the dataset in memory\r\n"} {
+			decoder.Decode()
+			server.Write([]byte(reply))
+		}
+	}()
+
+	ctx := context.Background()
+
+	if fallback, err := read(ctx, replica, NewRequest("GET", "a")); fallback || err != nil {
+		t.Fatal(fallback, err)
+	}
+
+	// errors of the command itself are final
+	if fallback, err := read(ctx, replica, NewRequest("GET", "b")); fallback || !IsWrongType(err) {
+		t.Fatal(fallback, err)
+	}
+
+	if fallback, err := read(ctx, replica, NewRequest("GET", "c")); !fallback || !IsLoading(err) {
+		t.Fatal(fallback, err)
+	}
+}
@@ -3,8 +3,10 @@
 package redis
 
 import (
-	"log"
-	"strings"
+	"context"
+	"errors"
+	"fmt"
+	"time"
 )
 
 type command struct {
@@ -23,6 +25,9 @@ type Request struct {
 	moved    bool
 	redirect bool
 	address  string
+	block    bool
+	timeout  time.Duration
+	ctx      context.Context
 	done     chan struct{}
 }
 
@@ -51,6 +56,22 @@ func (request *Request) Add(name string, args ...interface{}) {
 	})
 }
 
+// copy returns a new request holding the same commands without their results.
+func (request *Request) copy() (result *Request) {
+	result = &Request{
+		commands: make([]command, len(request.commands)),
+	}
+
+	for i := range request.commands {
+		result.commands[i] = command{
+			name: request.commands[i].name,
+			args: request.commands[i].args,
+		}
+	}
+
+	return
+}
+
 func (request *Request) encode(encoder *Encoder) (err error) {
 	for i := range request.commands {
 		err = request.commands[i].encode(encoder)
@@ -68,25 +89,27 @@ func (cmd *command) encode(encoder *Encoder) error {
 }
 
 func (request *Request) decode(decoder *Decoder) (err error) {
+	// every reply must be consumed to keep the stream in sync even after an error
 	for i := range request.commands {
-		err = request.commands[i].decode(decoder)
-		if err != nil {
-			break
+		if e := request.commands[i].decode(decoder); e != nil && err == nil {
+			err = e
 		}
 	}
 
-	if err != nil {
-		result, ok := request.commands[0].result.(string)
-		if ok {
-			if strings.HasPrefix(result, "MOVED") {
-				request.moved = true
-			}
+	request.moved = false
+	request.redirect = false
 
-			request.redirect = request.moved || strings.HasPrefix(result, "ASK")
-			if request.redirect {
-				request.address = "tcp://" + result[strings.LastIndex(result, " ")+1:]
-			}
-		}
+	var moved *MovedError
+	var ask *AskError
+
+	switch {
+	case errors.As(err, &moved):
+		request.moved = true
+		request.redirect = true
+		request.address = moved.Address
+	case errors.As(err, &ask):
+		request.redirect = true
+		request.address = ask.Address
 	}
 
 	request.err = err
@@ -108,24 +131,35 @@ func (request *Request) Send(s Sender) error {
 	return s.Send(request)
 }
 
-func (request *Request) Key(i int) string {
-	c := &request.commands[i]
+// ContextSender is implemented to support sending requests bound to a context.
+type ContextSender interface {
+	SendContext(context.Context, *Request) error
+}
 
-	if c.name == "EVALSHA" {
-		r, ok := c.args[2].(string)
-		if !ok {
-			log.Fatalln("expecting string", c.args)
-		}
+// SendContext sends a request that is abandoned when the context is done.
+func (request *Request) SendContext(ctx context.Context, s ContextSender) error {
+	return s.SendContext(ctx, request)
+}
 
-		return r
+// Key returns the first key of the specified command.
+func (request *Request) Key(i int) (string, error) {
+	c := &request.commands[i]
+
+	keys := c.keys(DefaultCommands)
+	if len(keys) == 0 {
+		return "", fmt.Errorf("redis command '%s' has no key", c.name)
 	}
 
-	r, ok := c.args[0].(string)
-	if !ok {
-		log.Fatalln("expecting string", c.args[0])
+	return string(keys[0]), nil
+}
+
+// Keys returns the keys of the specified command.
+func (request *Request) Keys(i int) (result []string) {
+	for _, key := range request.commands[i].keys(DefaultCommands) {
+		result = append(result, string(key))
 	}
 
-	return r
+	return
 }
 
 func (request *Request) Args(i int) []interface{} {
@@ -138,10 +172,17 @@ func (request *Request) Result(i int) (interface{}, error) {
 	return r.result, r.err
 }
 
-func (request *Request) slot() int {
+// slot returns the hash slot of the first key found in the commands of the request or 0 if there is none.
+func (request *Request) slot(table CommandTable) int {
 	if request.key == nil {
-		request.key = []byte(request.Key(0))
-		request.hash = slot(request.key)
+		request.key = []byte{}
+		for i := range request.commands {
+			if keys := request.commands[i].keys(table); len(keys) != 0 {
+				request.key = keys[0]
+				request.hash = slot(request.key)
+				break
+			}
+		}
 	}
 
 	return request.hash
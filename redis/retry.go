@@ -0,0 +1,120 @@
+// Copyright (c) 2015 Datacratic. All rights reserved.
+
+package redis
+
+import (
+	"context"
+	"math/rand"
+	"time"
+)
+
+// DefaultRetryKinds defines the kinds of errors retried by default which are transient conditions of the Redis instance or cluster.
+var DefaultRetryKinds = []string{"TRYAGAIN", "CLUSTERDOWN", "LOADING"}
+
+// DefaultMaximumAttempts defines the default maximum number of times a request is sent including the first one.
+var DefaultMaximumAttempts = 5
+
+// DefaultRetryBackoff defines the default delay multiplicatively increased between two attempts.
+var DefaultRetryBackoff = 50 * time.Millisecond
+
+// DefaultMaximumRetryBackoff defines the default maximum delay between two attempts.
+var DefaultMaximumRetryBackoff = time.Second
+
+// RetryPolicy defines how requests failing with transient errors are sent again.
+// A request is only retried when all its commands failed with one of the retried kinds so that none was applied.
+type RetryPolicy struct {
+	Kinds           []string
+	MaximumAttempts int
+	Backoff         time.Duration
+	MaximumBackoff  time.Duration
+
+	// Timeout bounds the time spent retrying since the first attempt when not 0.
+	Timeout time.Duration
+
+	// OnRetry is called before waiting for the specified delay to send the request again after the n-th attempt failed.
+	OnRetry func(request *Request, n int, err error, delay time.Duration)
+}
+
+// do sends the request with the function until it succeeds or the policy gives up.
+// A nil policy sends the request only once.
+func (policy *RetryPolicy) do(ctx context.Context, request *Request, f func(context.Context, *Request) error) (err error) {
+	if policy == nil {
+		return f(ctx, request)
+	}
+
+	attempts := policy.MaximumAttempts
+	if 0 == attempts {
+		attempts = DefaultMaximumAttempts
+	}
+
+	start := time.Now()
+	for n := 1; ; n++ {
+		err = f(ctx, request)
+
+		// replies of abandoned requests may still be written in the background
+		if err == nil || n >= attempts || ctx.Err() != nil || !policy.retryable(request) {
+			return
+		}
+
+		delay := policy.backoff(n)
+		if policy.Timeout > 0 && time.Since(start)+delay > policy.Timeout {
+			return
+		}
+
+		if policy.OnRetry != nil {
+			policy.OnRetry(request, n, err, delay)
+		}
+
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// retryable reports whether every command of the request failed with a retried kind of error.
+func (policy *RetryPolicy) retryable(request *Request) bool {
+	kinds := policy.Kinds
+	if len(kinds) == 0 {
+		kinds = DefaultRetryKinds
+	}
+
+	for i := range request.commands {
+		kind := ErrorKind(request.commands[i].err)
+
+		found := false
+		for _, k := range kinds {
+			if k == kind {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	return len(request.commands) != 0
+}
+
+// backoff returns the delay after the n-th attempt which grows exponentially up to a maximum and is randomized.
+func (policy *RetryPolicy) backoff(n int) time.Duration {
+	timeout := policy.Backoff
+	if 0 == timeout {
+		timeout = DefaultRetryBackoff
+	}
+
+	limit := policy.MaximumBackoff
+	if 0 == limit {
+		limit = DefaultMaximumRetryBackoff
+	}
+
+	d := limit
+	if n < 32 && timeout<<uint(n-1) < limit {
+		d = timeout << uint(n-1)
+	}
+
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
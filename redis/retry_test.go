@@ -0,0 +1,87 @@
+// Copyright (c) 2015 Datacratic. All rights reserved.
+
+package redis
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestRetry(t *testing.T) {
+	servers := make(chan net.Conn, 1)
+
+	var retries []int
+	conn := &Conn{
+		Retry: &RetryPolicy{
+			MaximumAttempts: 3,
+			Backoff:         time.Millisecond,
+			OnRetry: func(request *Request, n int, err error, delay time.Duration) {
+				if !IsLoading(err) {
+					t.Errorf("unexpected error '%s'", err)
+				}
+
+				retries = append(retries, n)
+			},
+		},
+		db: dialerFunc(func() (net.Conn, error) {
+			client, server := net.Pipe()
+			servers <- server
+			return client, nil
+		}),
+	}
+
+	defer conn.Close()
+
+	loading := "-LOADING Redis is loading the dataset in memory\r\n"
+
+	go func() {
+		server := <-servers
+		decoder := NewDecoder(server)
+		for _, item := range []struct {
+			commands int
+			reply    string
+		}{
+			{1, loading},
+			{1, loading},
+			{1, "+PONG\r\n"},
+			{1, loading},
+			{1, loading},
+			{1, loading},
+			{2, "+OK\r\n-TRYAGAIN Multiple keys request during rehashing of slot\r\n"},
+		} {
+			for i := 0; i < item.commands; i++ {
+				decoder.Decode()
+			}
+
+			server.Write([]byte(item.reply))
+		}
+	}()
+
+	if result, err := conn.Do("PING"); err != nil || result != "PONG" {
+		t.Fatal(err, result)
+	}
+
+	if len(retries) != 2 {
+		t.Fatalf("unexpected retries %v", retries)
+	}
+
+	// give up after the maximum number of attempts
+	retries = nil
+	if result, err := conn.Do("PING"); !IsLoading(err) || result != nil {
+		t.Fatal(err, result)
+	}
+
+	if len(retries) != 2 {
+		t.Fatalf("unexpected retries %v", retries)
+	}
+
+	// requests partially applied aren't retried
+	retries = nil
+	request := NewRequest("SET", "a", 1)
+	request.Add("MGET", "a", "b")
+	if err := request.SendContext(context.Background(), conn); !IsTryAgain(err) || len(retries) != 0 {
+		t.Fatal(err, retries)
+	}
+}
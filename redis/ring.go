@@ -0,0 +1,316 @@
+// Copyright (c) 2015 Datacratic. All rights reserved.
+
+package redis
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/datacratic/goblueprint/blueprint"
+)
+
+// ketamaPoints defines the number of points a node of average weight gets on the continuum, as with ketama.
+const ketamaPoints = 160
+
+// Ring implements a client distributing keys over independent Redis instances with a consistent hash.
+// The continuum is compatible with ketama (as used by twemproxy) where nodes are named host:port.
+// Keys with a hash tag such as {user}.name are placed by their tag only, as on a cluster.
+// Nodes whose connection fails are ejected from the ring as soon as they start reconnecting and until they succeed,
+// which moves their keys to the other nodes meanwhile.
+// Requests without keys go to the first healthy node.
+type Ring struct {
+	// Address lists the URLs of the instances as understood by ParseURL.
+	Address []string
+	// Weights gives the relative weight of each instance in the same order as Address and defaults to 1.
+	Weights []int
+
+	// The options are the same as the ones of Client and apply to the connection of every instance.
+	MaximumConcurrentRequests int
+	MaximumPendingRequests    int
+	MaximumConnectionRetries  int
+	RetryTimeout              time.Duration
+	Options                   DialOptions
+
+	// Commands describes where the keys of commands are found to route them to the right node.
+	// The built-in table is used when not set.
+	Commands CommandTable
+
+	// OnHealthChange is called in the background when a node is ejected from or inserted back in the ring.
+	OnHealthChange func(address string, healthy bool)
+
+	once      sync.Once
+	mu        sync.Mutex
+	nodes     []*ringNode
+	continuum atomic.Value
+}
+
+type ringNode struct {
+	address string
+	name    string
+	weight  int
+	conn    *Conn
+	ejected bool
+}
+
+// continuum holds the points of the healthy nodes sorted by hash.
+type continuum struct {
+	points []point
+	nodes  []*ringNode
+}
+
+type point struct {
+	hash uint32
+	node int
+}
+
+func (r *Ring) initialize() {
+	// nodes are dialed like the ones of a client with the same options
+	client := &Client{
+		MaximumConcurrentRequests: r.MaximumConcurrentRequests,
+		MaximumPendingRequests:    r.MaximumPendingRequests,
+		MaximumConnectionRetries:  r.MaximumConnectionRetries,
+		RetryTimeout:              r.RetryTimeout,
+		options:                   r.Options,
+	}
+
+	for i, address := range r.Address {
+		node := &ringNode{
+			address: address,
+			name:    address,
+			weight:  1,
+			conn:    client.connect(address),
+		}
+
+		if i < len(r.Weights) && r.Weights[i] > 0 {
+			node.weight = r.Weights[i]
+		}
+
+		if endpoint, err := parseURL(address, r.Options); err != nil {
+			log.Println("invalid address:", err)
+		} else {
+			node.name = endpoint.Address
+		}
+
+		// ejected nodes are probed in the background until they reconnect
+		node.conn.OnStateChange = func(last, next State) {
+			switch next {
+			case StateReconnecting, StateFailed:
+				r.setHealth(node, false)
+			case StateConnected:
+				r.setHealth(node, true)
+			}
+		}
+
+		r.nodes = append(r.nodes, node)
+	}
+
+	r.continuum.Store(newContinuum(r.nodes))
+}
+
+// newContinuum places the healthy nodes on the continuum or all of them if none is healthy.
+func newContinuum(nodes []*ringNode) (result *continuum) {
+	var healthy []*ringNode
+	for _, node := range nodes {
+		if !node.ejected {
+			healthy = append(healthy, node)
+		}
+	}
+
+	if len(healthy) == 0 {
+		healthy = nodes
+	}
+
+	total := 0
+	for _, node := range healthy {
+		total += node.weight
+	}
+
+	result = &continuum{
+		nodes: healthy,
+	}
+
+	for i, node := range healthy {
+		ratio := float64(node.weight) / float64(total)
+		n := int(math.Floor(ratio*ketamaPoints*float64(len(healthy))+0.0000000001)) / 4
+		for k := 0; k < n; k++ {
+			digest := md5.Sum([]byte(fmt.Sprintf("%s-%d", node.name, k)))
+			for h := 0; h < 4; h++ {
+				result.points = append(result.points, point{
+					hash: binary.LittleEndian.Uint32(digest[h*4:]),
+					node: i,
+				})
+			}
+		}
+	}
+
+	sort.Slice(result.points, func(i, j int) bool {
+		return result.points[i].hash < result.points[j].hash
+	})
+
+	return
+}
+
+// locate returns the index of the node owning the key.
+func (c *continuum) locate(key []byte) int {
+	if len(c.points) == 0 {
+		return 0
+	}
+
+	digest := md5.Sum(hashTag(key))
+	hash := binary.LittleEndian.Uint32(digest[:4])
+
+	i := sort.Search(len(c.points), func(i int) bool {
+		return c.points[i].hash >= hash
+	})
+
+	if i == len(c.points) {
+		i = 0
+	}
+
+	return c.points[i].node
+}
+
+// owner returns the index of the node owning every key of the request or the first node when there is none.
+// ErrCrossSlot is returned when the keys belong to different nodes.
+func (c *continuum) owner(request *Request, table CommandTable) (index int, err error) {
+	index = -1
+	for i := range request.commands {
+		for _, key := range request.commands[i].keys(table) {
+			n := c.locate(key)
+			if index >= 0 && n != index {
+				return 0, ErrCrossSlot
+			}
+
+			index = n
+		}
+	}
+
+	if index < 0 {
+		index = 0
+	}
+
+	return
+}
+
+// setHealth ejects or inserts back a node and rebuilds the continuum when its health changed.
+func (r *Ring) setHealth(node *ringNode, healthy bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if node.ejected != healthy {
+		return
+	}
+
+	node.ejected = !healthy
+	r.continuum.Store(newContinuum(r.nodes))
+
+	if !healthy {
+		go r.probe(node)
+	}
+
+	if f := r.OnHealthChange; f != nil {
+		go f(node.address, healthy)
+	}
+}
+
+// probe keeps sending PING to an ejected node since requests aren't routed to it anymore to make it reconnect.
+func (r *Ring) probe(node *ringNode) {
+	for n := 1; ; n++ {
+		r.mu.Lock()
+		ejected := node.ejected
+		r.mu.Unlock()
+
+		if !ejected || node.conn.State() == StateClosed {
+			return
+		}
+
+		if _, err := node.conn.Do("PING"); err == nil {
+			return
+		}
+
+		time.Sleep(node.conn.backoff(n))
+	}
+}
+
+// Do executes the specified command (with optional arguments) on the node owning its key and waits to decode the reply.
+func (r *Ring) Do(name string, args ...interface{}) (result interface{}, err error) {
+	return r.DoContext(context.Background(), name, args...)
+}
+
+// DoContext executes the specified command (with optional arguments) on the node owning its key and waits to decode the reply or for the context to be done.
+func (r *Ring) DoContext(ctx context.Context, name string, args ...interface{}) (result interface{}, err error) {
+	request := NewRequest(name, args...)
+	if err = r.SendContext(ctx, request); err == nil {
+		result = request.commands[len(request.commands)-1].result
+	}
+
+	return
+}
+
+// Send sends the specified request to the node owning its keys and waits for the reply.
+func (r *Ring) Send(request *Request) error {
+	return r.SendContext(context.Background(), request)
+}
+
+// SendContext sends the specified request to the node owning its keys and waits for the reply or for the context to be done.
+// MGET, MSET, DEL, EXISTS, UNLINK and TOUCH are split per node and their replies merged back.
+// Other requests fail with ErrCrossSlot when their keys belong to different nodes, e.g. RENAME or EVAL across nodes.
+// Requests failing because their node is unavailable are sent again if it was ejected from the ring in the meantime.
+func (r *Ring) SendContext(ctx context.Context, request *Request) (err error) {
+	r.once.Do(r.initialize)
+
+	state := r.continuum.Load().(*continuum)
+	if len(state.nodes) == 0 {
+		return ErrConnectionUnavailable
+	}
+
+	if parts := request.splitBy(state.locate); parts != nil {
+		return sendParts(ctx, r, request, parts)
+	}
+
+	for i := 0; i < len(r.nodes); i++ {
+		var index int
+		if index, err = state.owner(request, r.Commands); err != nil {
+			break
+		}
+
+		if err = state.nodes[index].conn.SendContext(ctx, request); err == nil || ctx.Err() != nil {
+			break
+		}
+
+		if !errors.Is(err, ErrConnectionUnavailable) {
+			break
+		}
+
+		next := r.continuum.Load().(*continuum)
+		if next == state {
+			break
+		}
+
+		state = next
+	}
+
+	return
+}
+
+// Close tears down the connections to the instances.
+func (r *Ring) Close() {
+	r.once.Do(r.initialize)
+
+	for _, node := range r.nodes {
+		node.conn.Close()
+	}
+}
+
+func init() {
+	blueprint.Register(Ring{})
+}
@@ -0,0 +1,400 @@
+// Copyright (c) 2015 Datacratic. All rights reserved.
+
+package redis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestContinuum(t *testing.T) {
+	nodes := []*ringNode{
+		{name: "127.0.0.1:6379", weight: 1},
+		{name: "127.0.0.1:6380", weight: 1},
+		{name: "127.0.0.1:6381", weight: 2},
+	}
+
+	ring := newContinuum(nodes)
+	if len(ring.points) != 480 {
+		t.Fatalf("unexpected number of points %d", len(ring.points))
+	}
+
+	// keys are spread according to the weights
+	counts := make([]int, len(nodes))
+	keys := make([]int, 10000)
+	for i := range keys {
+		keys[i] = ring.locate([]byte(fmt.Sprintf("key:%d", i)))
+		counts[keys[i]]++
+	}
+
+	if counts[2] < counts[0] || counts[2] < counts[1] {
+		t.Fatalf("unexpected distribution %v", counts)
+	}
+
+	// keys sharing a hash tag are on the same node
+	if ring.locate([]byte("{user}.name")) != ring.locate([]byte("user")) {
+		t.Fatal("hash tag ignored")
+	}
+
+	// only the keys of an ejected node move when weights are equal
+	nodes[2].weight = 1
+	ring = newContinuum(nodes)
+	for i := range keys {
+		keys[i] = ring.locate([]byte(fmt.Sprintf("key:%d", i)))
+	}
+
+	nodes[0].ejected = true
+	next := newContinuum(nodes)
+	for i := range keys {
+		n := next.locate([]byte(fmt.Sprintf("key:%d", i)))
+		if keys[i] != 0 && next.nodes[n] != nodes[keys[i]] {
+			t.Fatalf("key:%d moved from node %d", i, keys[i])
+		}
+	}
+}
+
+// TestKetama checks the continuum against points and placements computed with the algorithm of twemproxy.
+func TestKetama(t *testing.T) {
+	nodes := []*ringNode{
+		{name: "127.0.0.1:6379", weight: 1},
+		{name: "127.0.0.1:6380", weight: 1},
+		{name: "127.0.0.1:6381", weight: 1},
+	}
+
+	tests := []struct {
+		weight int
+		keys   map[string]int
+	}{
+		{1, map[string]int{"foo": 0, "bar": 2, "baz": 2, "key:0": 1, "key:1": 1, "key:2": 0, "key:3": 2, "key:4": 0, "key:5": 0, "user:1000": 2}},
+		{2, map[string]int{"foo": 2, "bar": 2, "baz": 2, "key:0": 2, "key:1": 1, "key:2": 0, "key:3": 2, "key:4": 0, "key:5": 2, "user:1000": 2}},
+	}
+
+	for _, test := range tests {
+		nodes[2].weight = test.weight
+		ring := newContinuum(nodes)
+
+		first := []point{{20075150, 0}, {23409604, 1}, {29345296, 1}}
+		if len(ring.points) != 480 || !reflect.DeepEqual(ring.points[:3], first) || ring.points[479] != (point{4289099568, 2}) {
+			t.Fatalf("unexpected points with weight %d", test.weight)
+		}
+
+		for key, node := range test.keys {
+			if n := ring.locate([]byte(key)); n != node {
+				t.Fatalf("key '%s' located on node %d instead of %d with weight %d", key, n, node, test.weight)
+			}
+		}
+	}
+}
+
+func TestRing(t *testing.T) {
+	var dbs []*DB
+	defer func() {
+		for _, db := range dbs {
+			db.Close()
+		}
+	}()
+
+	var address []string
+	for i := 0; i < 3; i++ {
+		db, err := NewTestDB()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		dbs = append(dbs, db)
+		address = append(address, db.URL())
+	}
+
+	events := make(chan bool, 4)
+	ring := &Ring{
+		Address:                  address,
+		MaximumConnectionRetries: 1,
+		RetryTimeout:             10 * time.Millisecond,
+		OnHealthChange: func(address string, healthy bool) {
+			events <- healthy
+		},
+	}
+
+	defer ring.Close()
+
+	args := make([]interface{}, 0, 200)
+	for i := 0; i < 100; i++ {
+		args = append(args, fmt.Sprintf("key:%d", i), i)
+	}
+
+	if result, err := ring.Do("MSET", args...); err != nil || result != OK {
+		t.Fatal(err, result)
+	}
+
+	// every instance got some keys
+	for _, db := range dbs {
+		conn := db.Dial()
+		n, err := conn.Do("DBSIZE")
+		conn.Close()
+
+		if err != nil || n == int64(0) {
+			t.Fatal(err, n)
+		}
+	}
+
+	if result, err := ring.Do("EXISTS", "key:1", "key:2", "key:3", "missing"); err != nil || result != int64(3) {
+		t.Fatal(err, result)
+	}
+
+	// keys are moved to the other instances once one fails
+	dbs[0].Close()
+	dbs = dbs[1:]
+
+	// requests already written to the failed instance may fail but the unavailable ones are sent again to the others
+	for i := 0; i < 100; i++ {
+		if _, err := ring.Do("SET", fmt.Sprintf("key:%d", i), i); errors.Is(err, ErrConnectionUnavailable) {
+			t.Fatal(err)
+		}
+	}
+
+	select {
+	case healthy := <-events:
+		if healthy {
+			t.Fatal("unexpected insertion")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("instance wasn't ejected")
+	}
+
+	for i := 0; i < 100; i++ {
+		if _, err := ring.Do("SET", fmt.Sprintf("key:%d", i), i); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestContinuumOwner(t *testing.T) {
+	ring := newContinuum([]*ringNode{
+		{name: "127.0.0.1:6379", weight: 1},
+		{name: "127.0.0.1:6380", weight: 1},
+	})
+
+	// find two keys living on different nodes
+	a, b := "key:0", ""
+	for i := 1; b == ""; i++ {
+		if key := fmt.Sprintf("key:%d", i); ring.locate([]byte(key)) != ring.locate([]byte(a)) {
+			b = key
+		}
+	}
+
+	if index, err := ring.owner(NewRequest("PING"), nil); err != nil || index != 0 {
+		t.Fatal(err, index)
+	}
+
+	if index, err := ring.owner(NewRequest("RENAME", "{"+a+"}.x", a), nil); err != nil || index != ring.locate([]byte(a)) {
+		t.Fatal(err, index)
+	}
+
+	if _, err := ring.owner(NewRequest("RENAME", a, b), nil); err != ErrCrossSlot {
+		t.Fatal(err)
+	}
+
+	if _, err := ring.owner(NewRequest("EVAL", "return 1", 2, a, b), nil); err != ErrCrossSlot {
+		t.Fatal(err)
+	}
+
+	// the commands of a pipeline must all go to the same node
+	request := NewRequest("GET", a)
+	request.Add("GET", b)
+	if _, err := ring.owner(request, nil); err != ErrCrossSlot {
+		t.Fatal(err)
+	}
+}
+
+// ringServer returns a dialer to a fake instance that replies OK to every command while it is available.
+// The commands other than the PING probing the instance are counted.
+func ringServer(served *int32, available func() bool) dialer {
+	return dialerFunc(func() (net.Conn, error) {
+		if !available() {
+			return nil, errors.New("connection refused")
+		}
+
+		client, server := net.Pipe()
+		go func() {
+			decoder := NewDecoder(server)
+			for {
+				cmd, err := decoder.Decode()
+				if err != nil {
+					return
+				}
+
+				if args, _ := cmd.([]interface{}); len(args) != 0 && string(args[0].([]byte)) != "PING" {
+					atomic.AddInt32(served, 1)
+				}
+
+				server.Write([]byte("+OK\r\n"))
+			}
+		}()
+
+		return client, nil
+	})
+}
+
+func TestRingHealth(t *testing.T) {
+	var up int32
+	served := make([]int32, 2)
+
+	events := make(chan bool, 4)
+	ring := &Ring{
+		Address:                  []string{"tcp://127.0.0.1:7000", "tcp://127.0.0.1:7001"},
+		MaximumConnectionRetries: 1,
+		RetryTimeout:             time.Millisecond,
+		OnHealthChange: func(address string, healthy bool) {
+			if address == "tcp://127.0.0.1:7000" {
+				events <- healthy
+			}
+		},
+	}
+
+	ring.once.Do(ring.initialize)
+	defer ring.Close()
+
+	ring.nodes[0].conn.db = ringServer(&served[0], func() bool {
+		return atomic.LoadInt32(&up) != 0
+	})
+
+	ring.nodes[1].conn.db = ringServer(&served[1], func() bool {
+		return true
+	})
+
+	wait := func(expected bool) {
+		select {
+		case healthy := <-events:
+			if healthy != expected {
+				t.Fatalf("unexpected health %v", healthy)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("health didn't change to %v", expected)
+		}
+	}
+
+	// find a key of the first instance
+	state := ring.continuum.Load().(*continuum)
+	key := ""
+	for i := 0; key == ""; i++ {
+		if k := fmt.Sprintf("key:%d", i); state.nodes[state.locate([]byte(k))] == ring.nodes[0] {
+			key = k
+		}
+	}
+
+	// the key moves to the other instance while the first one is ejected
+	if result, err := ring.Do("SET", key, 1); err != nil || result != OK {
+		t.Fatal(err, result)
+	}
+
+	wait(false)
+
+	if n := atomic.LoadInt32(&served[1]); n != 1 {
+		t.Fatalf("unexpected number of commands %d on the remaining instance", n)
+	}
+
+	// and comes back once the instance is inserted back in the ring
+	atomic.StoreInt32(&up, 1)
+	wait(true)
+
+	if n := len(ring.continuum.Load().(*continuum).nodes); n != 2 {
+		t.Fatalf("unexpected number of nodes %d", n)
+	}
+
+	if result, err := ring.Do("SET", key, 2); err != nil || result != OK {
+		t.Fatal(err, result)
+	}
+
+	if n := atomic.LoadInt32(&served[0]); n != 1 {
+		t.Fatalf("unexpected number of commands %d on the recovered instance", n)
+	}
+}
+
+func TestRingReconnecting(t *testing.T) {
+	var up int32
+	served := make([]int32, 2)
+
+	events := make(chan bool, 4)
+	ring := &Ring{
+		Address:                  []string{"tcp://127.0.0.1:7000", "tcp://127.0.0.1:7001"},
+		MaximumConnectionRetries: 8,
+		RetryTimeout:             500 * time.Millisecond,
+		OnHealthChange: func(address string, healthy bool) {
+			if address == "tcp://127.0.0.1:7000" {
+				events <- healthy
+			}
+		},
+	}
+
+	ring.once.Do(ring.initialize)
+	defer ring.Close()
+
+	ring.nodes[0].conn.db = ringServer(&served[0], func() bool {
+		return atomic.LoadInt32(&up) != 0
+	})
+
+	ring.nodes[1].conn.db = ringServer(&served[1], func() bool {
+		return true
+	})
+
+	state := ring.continuum.Load().(*continuum)
+	key := ""
+	for i := 0; key == ""; i++ {
+		if k := fmt.Sprintf("key:%d", i); state.nodes[state.locate([]byte(k))] == ring.nodes[0] {
+			key = k
+		}
+	}
+
+	// the first request waits for the instance to reconnect which ejects it right away
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	if _, err := ring.DoContext(ctx, "SET", key, 1); err != context.DeadlineExceeded {
+		t.Fatal(err)
+	}
+
+	select {
+	case healthy := <-events:
+		if healthy {
+			t.Fatal("unexpected insertion")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("reconnecting instance wasn't ejected")
+	}
+
+	if state := ring.nodes[0].conn.State(); state != StateReconnecting {
+		t.Fatalf("unexpected state %s", state)
+	}
+
+	// the key goes to the next node of the ring meanwhile without waiting
+	start := time.Now()
+	if result, err := ring.Do("SET", key, 2); err != nil || result != OK {
+		t.Fatal(err, result)
+	}
+
+	if d := time.Since(start); d > 250*time.Millisecond {
+		t.Fatalf("request waited %s for the reconnecting instance", d)
+	}
+
+	if n := atomic.LoadInt32(&served[1]); n != 1 {
+		t.Fatalf("unexpected number of commands %d on the next instance", n)
+	}
+
+	// the instance is probed until it reconnects
+	atomic.StoreInt32(&up, 1)
+
+	select {
+	case healthy := <-events:
+		if !healthy {
+			t.Fatal("unexpected ejection")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("instance wasn't inserted back")
+	}
+}
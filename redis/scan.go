@@ -0,0 +1,355 @@
+// Copyright (c) 2015 Datacratic. All rights reserved.
+
+package redis
+
+import (
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Unmarshaler is implemented by objects that want to unmarshal their Redis representation.
+// Scalar replies are given as text which makes it the counterpart of Marshaler.
+type Unmarshaler interface {
+	UnmarshalREDIS([]byte) error
+}
+
+var unmarshalerType = reflect.TypeOf((*Unmarshaler)(nil)).Elem()
+var textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+
+// UnmarshalReply converts a decoded reply into the value pointed to by v.
+// Bulk strings, integers and doubles are converted to numbers, strings, booleans or []byte as needed.
+// Arrays fill slices, flat arrays of field/value pairs (as returned by HGETALL) or maps fill maps and structs.
+// Struct fields are matched by name or by their `redis:"name"` tag and skipped with `redis:"-"`.
+// Bulk strings unmarshaled into structs are expected to hold JSON as produced by the Encoder.
+func UnmarshalReply(reply interface{}, v interface{}) error {
+	value := reflect.ValueOf(v)
+	if value.Kind() != reflect.Ptr || value.IsNil() {
+		return fmt.Errorf("redis: cannot unmarshal into non-pointer %T", v)
+	}
+
+	return unmarshal(reply, value.Elem())
+}
+
+// Scan copies the elements of an array reply into the values pointed to by dst in order.
+func Scan(reply interface{}, dst ...interface{}) error {
+	items, ok := reply.([]interface{})
+	if !ok {
+		return fmt.Errorf("redis: cannot scan %T, expecting an array", reply)
+	}
+
+	if len(items) < len(dst) {
+		return fmt.Errorf("redis: cannot scan %d values from an array of %d", len(dst), len(items))
+	}
+
+	for i := range dst {
+		if err := UnmarshalReply(items[i], dst[i]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func unmarshal(reply interface{}, value reflect.Value) (err error) {
+	if e, ok := reply.(error); ok {
+		return e
+	}
+
+	if reply == nil {
+		value.Set(reflect.Zero(value.Type()))
+		return
+	}
+
+	// custom representations first
+	if value.CanAddr() {
+		switch t := value.Addr().Type(); {
+		case t.Implements(unmarshalerType):
+			var text []byte
+			if text, err = scalar(reply); err == nil {
+				err = value.Addr().Interface().(Unmarshaler).UnmarshalREDIS(text)
+			}
+
+			return
+		case t.Implements(textUnmarshalerType):
+			var text []byte
+			if text, err = scalar(reply); err == nil {
+				err = value.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText(text)
+			}
+
+			return
+		}
+	}
+
+	switch value.Kind() {
+	case reflect.Interface:
+		if value.NumMethod() == 0 {
+			value.Set(reflect.ValueOf(reply))
+			return
+		}
+	case reflect.Ptr:
+		if value.IsNil() {
+			value.Set(reflect.New(value.Type().Elem()))
+		}
+
+		return unmarshal(reply, value.Elem())
+	case reflect.String:
+		var text []byte
+		if text, err = scalar(reply); err == nil {
+			value.SetString(string(text))
+		}
+
+		return
+	case reflect.Bool:
+		switch r := reply.(type) {
+		case bool:
+			value.SetBool(r)
+		case int64:
+			value.SetBool(r != 0)
+		default:
+			var text []byte
+			if text, err = scalar(reply); err == nil {
+				var b bool
+				if b, err = strconv.ParseBool(string(text)); err == nil {
+					value.SetBool(b)
+				}
+			}
+		}
+
+		return
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if r, ok := reply.(int64); ok {
+			value.SetInt(r)
+			return
+		}
+
+		// durations are either nanoseconds or text as written by Args
+		if value.Type() == durationType {
+			var text []byte
+			if text, err = scalar(reply); err == nil {
+				var d time.Duration
+				if d, err = time.ParseDuration(string(text)); err != nil {
+					var n int64
+					if n, err = strconv.ParseInt(string(text), 10, 64); err == nil {
+						d = time.Duration(n)
+					}
+				}
+
+				value.SetInt(int64(d))
+			}
+
+			return
+		}
+
+		var text []byte
+		if text, err = scalar(reply); err == nil {
+			var n int64
+			if n, err = strconv.ParseInt(string(text), 10, value.Type().Bits()); err == nil {
+				value.SetInt(n)
+			}
+		}
+
+		return
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		var text []byte
+		if text, err = scalar(reply); err == nil {
+			var n uint64
+			if n, err = strconv.ParseUint(string(text), 10, value.Type().Bits()); err == nil {
+				value.SetUint(n)
+			}
+		}
+
+		return
+	case reflect.Float32, reflect.Float64:
+		if r, ok := reply.(float64); ok {
+			value.SetFloat(r)
+			return
+		}
+
+		var text []byte
+		if text, err = scalar(reply); err == nil {
+			var f float64
+			if f, err = strconv.ParseFloat(string(text), value.Type().Bits()); err == nil {
+				value.SetFloat(f)
+			}
+		}
+
+		return
+	case reflect.Slice:
+		if value.Type().Elem().Kind() == reflect.Uint8 {
+			var text []byte
+			if text, err = scalar(reply); err == nil {
+				value.SetBytes(append([]byte(nil), text...))
+			}
+
+			return
+		}
+
+		items, ok := reply.([]interface{})
+		if !ok {
+			break
+		}
+
+		result := reflect.MakeSlice(value.Type(), len(items), len(items))
+		for i := range items {
+			if err = unmarshal(items[i], result.Index(i)); err != nil {
+				return
+			}
+		}
+
+		value.Set(result)
+		return
+	case reflect.Map:
+		var pairs []interface{}
+		if pairs, err = fields(reply); err != nil {
+			return
+		}
+
+		t := value.Type()
+		result := reflect.MakeMapWithSize(t, len(pairs)/2)
+		for i := 0; i < len(pairs); i += 2 {
+			k := reflect.New(t.Key()).Elem()
+			if err = unmarshal(pairs[i], k); err != nil {
+				return
+			}
+
+			v := reflect.New(t.Elem()).Elem()
+			if err = unmarshal(pairs[i+1], v); err != nil {
+				return
+			}
+
+			result.SetMapIndex(k, v)
+		}
+
+		value.Set(result)
+		return
+	case reflect.Struct:
+		// values stored by the encoder are JSON documents
+		if data, ok := reply.([]byte); ok {
+			return json.Unmarshal(data, value.Addr().Interface())
+		}
+
+		var pairs []interface{}
+		if pairs, err = fields(reply); err != nil {
+			return
+		}
+
+		index := make(map[string]structField)
+		for _, f := range structFields(value.Type()) {
+			index[f.name] = f
+		}
+
+		for i := 0; i < len(pairs); i += 2 {
+			var name []byte
+			if name, err = scalar(pairs[i]); err != nil {
+				return
+			}
+
+			f, ok := index[string(name)]
+			if !ok {
+				continue
+			}
+
+			field := value.FieldByIndex(f.index)
+			if data, ok := pairs[i+1].([]byte); ok && strings.Contains(f.options(), ",json,") {
+				err = json.Unmarshal(data, field.Addr().Interface())
+			} else {
+				err = unmarshal(pairs[i+1], field)
+			}
+
+			if err != nil {
+				return fmt.Errorf("redis: cannot unmarshal field '%s': %s", name, err)
+			}
+		}
+
+		return
+	}
+
+	return fmt.Errorf("redis: cannot unmarshal %T into %s", reply, value.Type())
+}
+
+// scalar returns the text representation of a non-aggregate reply.
+func scalar(reply interface{}) (result []byte, err error) {
+	switch r := reply.(type) {
+	case []byte:
+		result = r
+	case string:
+		result = []byte(r)
+	case int64:
+		result = strconv.AppendInt(nil, r, 10)
+	case float64:
+		result = strconv.AppendFloat(nil, r, 'g', -1, 64)
+	case bool:
+		result = strconv.AppendBool(nil, r)
+	case fmt.Stringer:
+		result = []byte(r.String())
+	default:
+		err = fmt.Errorf("redis: cannot convert %T to text", reply)
+	}
+
+	return
+}
+
+// fields returns the flat list of field/value pairs of an array or map reply.
+func fields(reply interface{}) (result []interface{}, err error) {
+	switch r := reply.(type) {
+	case []interface{}:
+		if len(r)%2 != 0 {
+			err = fmt.Errorf("redis: expecting field/value pairs, got %d values", len(r))
+			return
+		}
+
+		result = r
+	case map[interface{}]interface{}:
+		result = make([]interface{}, 0, 2*len(r))
+		for k, v := range r {
+			result = append(result, k, v)
+		}
+	default:
+		err = fmt.Errorf("redis: cannot convert %T to field/value pairs", reply)
+	}
+
+	return
+}
+
+type structField struct {
+	name  string
+	index []int
+	tag   string
+}
+
+// structFields lists the exported fields of a struct with their Redis name.
+func structFields(t reflect.Type) (result []structField) {
+	for i, n := 0, t.NumField(); i < n; i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+
+		tag := f.Tag.Get("redis")
+		if tag == "-" {
+			continue
+		}
+
+		name := tag
+		if j := strings.IndexByte(tag, ','); j >= 0 {
+			name = tag[:j]
+		}
+
+		if name == "" {
+			name = f.Name
+		}
+
+		result = append(result, structField{
+			name:  name,
+			index: f.Index,
+			tag:   tag,
+		})
+	}
+
+	return
+}
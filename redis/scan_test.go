@@ -0,0 +1,102 @@
+// Copyright (c) 2015 Datacratic. All rights reserved.
+
+package redis
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type upper string
+
+func (u *upper) UnmarshalREDIS(data []byte) error {
+	*u = upper(strings.ToUpper(string(data)))
+	return nil
+}
+
+type testUser struct {
+	Name    string `redis:"name"`
+	Age     int    `redis:"age"`
+	Score   float64
+	Admin   bool          `redis:"admin"`
+	Nick    upper         `redis:"nick"`
+	Created time.Time     `redis:"created"`
+	Skipped string        `redis:"-"`
+	Tags    []string      `redis:"tags"`
+	TTL     time.Duration `redis:"ttl"`
+}
+
+func TestUnmarshalReply(t *testing.T) {
+	var n int
+	if err := UnmarshalReply([]byte("42"), &n); err != nil || n != 42 {
+		t.Fatal(err, n)
+	}
+
+	var f float64
+	if err := UnmarshalReply(int64(3), &f); err != nil || f != 3 {
+		t.Fatal(err, f)
+	}
+
+	var s []string
+	if err := UnmarshalReply([]interface{}{[]byte("a"), nil, int64(1)}, &s); err != nil || !reflect.DeepEqual(s, []string{"a", "", "1"}) {
+		t.Fatal(err, s)
+	}
+
+	var m map[string]int
+	if err := UnmarshalReply([]interface{}{[]byte("a"), []byte("1"), []byte("b"), []byte("2")}, &m); err != nil || !reflect.DeepEqual(m, map[string]int{"a": 1, "b": 2}) {
+		t.Fatal(err, m)
+	}
+
+	reply := []interface{}{
+		[]byte("name"), []byte("John"),
+		[]byte("age"), []byte("42"),
+		[]byte("Score"), []byte("3.5"),
+		[]byte("admin"), []byte("1"),
+		[]byte("nick"), []byte("jd"),
+		[]byte("created"), []byte("2015-01-02T03:04:05Z"),
+		[]byte("Skipped"), []byte("no"),
+		[]byte("unknown"), []byte("ignored"),
+		[]byte("ttl"), []byte("1000000000"),
+	}
+
+	var user testUser
+	if err := UnmarshalReply(reply, &user); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := testUser{
+		Name:    "John",
+		Age:     42,
+		Score:   3.5,
+		Admin:   true,
+		Nick:    "JD",
+		Created: time.Date(2015, 1, 2, 3, 4, 5, 0, time.UTC),
+		TTL:     time.Second,
+	}
+
+	if !reflect.DeepEqual(user, expected) {
+		t.Fatalf("unexpected result '%+v' instead of '%+v'", user, expected)
+	}
+
+	// values written by the encoder are JSON documents
+	var obj struct {
+		N int
+		B bool
+	}
+
+	if err := UnmarshalReply([]byte(`{"N":1,"B":true}`), &obj); err != nil || obj.N != 1 || !obj.B {
+		t.Fatal(err, obj)
+	}
+
+	var a, b string
+	var c int
+	if err := Scan([]interface{}{[]byte("a"), []byte("b"), int64(3)}, &a, &b, &c); err != nil || a != "a" || b != "b" || c != 3 {
+		t.Fatal(err, a, b, c)
+	}
+
+	if err := UnmarshalReply([]byte("x"), &n); err == nil {
+		t.Fatal("expected error")
+	}
+}
@@ -0,0 +1,349 @@
+// Copyright (c) 2015 Datacratic. All rights reserved.
+
+package redis
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Sentinel implements a client to a Redis database whose master is discovered through Redis Sentinel.
+// The master is asked to the sentinels each time a connection is established and the connection is
+// reestablished when the sentinels announce a failover with +switch-master.
+// Requests waiting to be sent are kept and go to the new master.
+// Announcements are received from one sentinel at a time which changes each time the subscription is lost.
+type Sentinel struct {
+	// Sentinels lists the URLs of the sentinels.
+	Sentinels []string
+	// MasterName is the name under which the sentinels monitor the master.
+	MasterName string
+	// ReadFromReplica sends read-only requests to a random replica and falls back to the master when it fails.
+	ReadFromReplica bool
+	// Options defines how connections to the master and the replicas are established.
+	Options DialOptions
+
+	// OnSwitch is called in the background when the master changed.
+	OnSwitch func(last, next string)
+
+	mu        sync.Mutex
+	once      sync.Once
+	address   string
+	master    *Conn
+	replicas  map[string]*Conn
+	sentinels []*Conn
+	endpoints []*Endpoint
+	pubsub    *PubSub
+	closed    bool
+
+	// next is the index of the sentinel the subscription tries first when connecting.
+	next int
+}
+
+func (s *Sentinel) initialize() {
+	for _, text := range s.Sentinels {
+		endpoint, err := ParseURL(text)
+		if err != nil {
+			log.Println("invalid sentinel:", err)
+			continue
+		}
+
+		// a sentinel that is down must not delay asking the others
+		conn := endpoint.Dial()
+		conn.MaximumConnectionRetries = 1
+
+		s.sentinels = append(s.sentinels, conn)
+		s.endpoints = append(s.endpoints, endpoint)
+	}
+
+	s.replicas = make(map[string]*Conn)
+
+	// the master is looked up each time a connection is established
+	s.master = &Conn{
+		Options: s.Options,
+	}
+
+	s.master.db = dialerFunc(func() (net.Conn, error) {
+		address, err := s.discover()
+		if err != nil {
+			return nil, err
+		}
+
+		return s.master.Options.dial("tcp", address)
+	})
+
+	// the subscription moves to the next sentinel when the connection fails
+	if len(s.endpoints) != 0 {
+		events := &Conn{
+			Options: s.endpoints[0].Options,
+			db:      dialerFunc(s.dialSentinel),
+		}
+
+		channels := []string{"+switch-master"}
+		if s.ReadFromReplica {
+			channels = append(channels, "+slave", "+sdown", "-sdown")
+		}
+
+		s.pubsub = events.PubSub()
+		s.pubsub.OnMessage = s.deliver
+		if err := s.pubsub.Subscribe(channels...); err != nil {
+			log.Println("sentinel subscription error:", err)
+		}
+	}
+}
+
+// Address returns the address of the master as last known.
+func (s *Sentinel) Address() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.address
+}
+
+// Do executes the specified command (with optional arguments) on the master and waits to decode the reply.
+func (s *Sentinel) Do(name string, args ...interface{}) (result interface{}, err error) {
+	return s.DoContext(context.Background(), name, args...)
+}
+
+// DoContext executes the specified command (with optional arguments) on the master and waits to decode the reply or for the context to be done.
+func (s *Sentinel) DoContext(ctx context.Context, name string, args ...interface{}) (result interface{}, err error) {
+	request := NewRequest(name, args...)
+	if err = s.SendContext(ctx, request); err == nil {
+		result = request.commands[len(request.commands)-1].result
+	}
+
+	return
+}
+
+// Send sends the specified request to the master and waits for the reply.
+func (s *Sentinel) Send(request *Request) error {
+	return s.SendContext(context.Background(), request)
+}
+
+// SendContext sends the specified request to the master, or a replica for reads when enabled, and waits for the reply or for the context to be done.
+func (s *Sentinel) SendContext(ctx context.Context, request *Request) (err error) {
+	s.once.Do(s.initialize)
+
+	if s.ReadFromReplica && request.readOnly(nil) {
+		if node := s.replica(); node != nil {
+			fallback := false
+			if fallback, err = read(ctx, node, request); !fallback {
+				return
+			}
+		}
+	}
+
+	return s.master.SendContext(ctx, request)
+}
+
+// PubSub creates a subscriber that connects to the master.
+func (s *Sentinel) PubSub() *PubSub {
+	s.once.Do(s.initialize)
+	return s.master.PubSub()
+}
+
+// Close tears down the connections to the sentinels, the master and the replicas.
+func (s *Sentinel) Close() {
+	s.once.Do(func() {})
+
+	s.mu.Lock()
+	s.closed = true
+	replicas := s.replicas
+	s.replicas = nil
+	s.mu.Unlock()
+
+	if s.pubsub != nil {
+		s.pubsub.Close()
+	}
+
+	for _, conn := range s.sentinels {
+		conn.Close()
+	}
+
+	for _, conn := range replicas {
+		conn.Close()
+	}
+
+	s.master.Close()
+}
+
+// discover asks the sentinels for the address of the master.
+func (s *Sentinel) discover() (address string, err error) {
+	err = fmt.Errorf("no sentinel available")
+	for _, conn := range s.sentinels {
+		var reply interface{}
+		if reply, err = conn.Do("SENTINEL", "get-master-addr-by-name", s.MasterName); err != nil {
+			continue
+		}
+
+		items, ok := reply.([]interface{})
+		if !ok || len(items) != 2 {
+			err = fmt.Errorf("master '%s' is unknown to the sentinel", s.MasterName)
+			continue
+		}
+
+		address = net.JoinHostPort(argText(items[0]), argText(items[1]))
+		break
+	}
+
+	if err != nil {
+		return
+	}
+
+	s.mu.Lock()
+	last := s.address
+	s.address = address
+	s.mu.Unlock()
+
+	if last != "" && last != address && s.OnSwitch != nil {
+		go s.OnSwitch(last, address)
+	}
+
+	return
+}
+
+// dialSentinel connects to the first reachable sentinel starting after the one used last.
+func (s *Sentinel) dialSentinel() (conn net.Conn, err error) {
+	s.mu.Lock()
+	start := s.next
+	s.next++
+	s.mu.Unlock()
+
+	for i := range s.endpoints {
+		endpoint := s.endpoints[(start+i)%len(s.endpoints)]
+		if conn, err = endpoint.Options.dial(endpoint.Network, endpoint.Address); err == nil {
+			return
+		}
+	}
+
+	return
+}
+
+// deliver handles the messages of the sentinels.
+func (s *Sentinel) deliver(message *Message) {
+	switch message.Kind {
+	case "subscribe":
+		// events may have been missed while subscribing to another sentinel
+		if message.Channel == "+switch-master" {
+			go s.verify()
+		}
+	case "message":
+		fields := strings.Fields(string(message.Data))
+		switch message.Channel {
+		case "+switch-master":
+			// <master name> <old ip> <old port> <new ip> <new port>
+			if len(fields) != 5 || fields[0] != s.MasterName {
+				return
+			}
+
+			s.master.reconnect()
+
+			if s.ReadFromReplica {
+				go s.updateReplicas()
+			}
+		default:
+			// <instance type> <name> <ip> <port> @ <master name> <master ip> <master port>
+			if len(fields) == 8 && fields[0] == "slave" && fields[4] == "@" && fields[5] == s.MasterName {
+				go s.updateReplicas()
+			}
+		}
+	}
+}
+
+// verify asks the sentinels for the master and its replicas again and reconnects if the master changed.
+func (s *Sentinel) verify() {
+	last := s.Address()
+	if address, err := s.discover(); err == nil && last != "" && last != address {
+		s.master.reconnect()
+	}
+
+	if s.ReadFromReplica {
+		s.updateReplicas()
+	}
+}
+
+// replica returns a random replica if any.
+func (s *Sentinel) replica() *Conn {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := len(s.replicas)
+	if n == 0 {
+		return nil
+	}
+
+	i := rand.Intn(n)
+	for _, conn := range s.replicas {
+		if i == 0 {
+			return conn
+		}
+
+		i--
+	}
+
+	return nil
+}
+
+// updateReplicas asks the sentinels for the healthy replicas of the master.
+func (s *Sentinel) updateReplicas() {
+	var reply interface{}
+
+	err := fmt.Errorf("no sentinel available")
+	for _, conn := range s.sentinels {
+		if reply, err = conn.Do("SENTINEL", "replicas", s.MasterName); err == nil {
+			break
+		}
+	}
+
+	if err != nil {
+		log.Println("sentinel replicas error:", err)
+		return
+	}
+
+	items, _ := reply.([]interface{})
+
+	next := make(map[string]bool)
+	for _, item := range items {
+		fields, _ := item.([]interface{})
+
+		info := make(map[string]string)
+		for i := 0; i+1 < len(fields); i += 2 {
+			info[argText(fields[i])] = argText(fields[i+1])
+		}
+
+		flags := info["flags"]
+		if strings.Contains(flags, "down") || strings.Contains(flags, "disconnected") {
+			continue
+		}
+
+		if _, err := strconv.Atoi(info["port"]); err != nil || info["ip"] == "" {
+			continue
+		}
+
+		next[net.JoinHostPort(info["ip"], info["port"])] = true
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return
+	}
+
+	for address, conn := range s.replicas {
+		if !next[address] {
+			delete(s.replicas, address)
+			go conn.Close()
+		}
+	}
+
+	for address := range next {
+		if _, ok := s.replicas[address]; !ok {
+			s.replicas[address] = DialWithOptions("tcp", address, s.Options)
+		}
+	}
+}
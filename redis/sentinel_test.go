@@ -0,0 +1,155 @@
+// Copyright (c) 2015 Datacratic. All rights reserved.
+
+package redis
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSentinel(t *testing.T) {
+	topology, err := NewTestTopology()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer topology.Close()
+
+	switched := make(chan string, 1)
+
+	client := topology.Dial()
+	client.ReadFromReplica = true
+	client.OnSwitch = func(last, next string) {
+		switched <- next
+	}
+
+	defer client.Close()
+
+	if result, err := client.Do("SET", "hello", "world"); err != nil || result != OK {
+		t.Fatal(err, result)
+	}
+
+	if address := client.Address(); address != topology.Master.addr {
+		t.Fatalf("unexpected master '%s' instead of '%s'", address, topology.Master.addr)
+	}
+
+	if err := topology.Failover(); err != nil {
+		t.Fatal(err)
+	}
+
+	// keep sending requests while the master changes
+	deadline := time.Now().Add(30 * time.Second)
+	for client.Address() != topology.Replica.addr {
+		if time.Now().After(deadline) {
+			t.Fatal("failover didn't happen")
+		}
+
+		client.Do("INCR", "counter")
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	select {
+	case next := <-switched:
+		if next != topology.Replica.addr {
+			t.Fatalf("unexpected switch to '%s'", next)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("switch wasn't reported")
+	}
+
+	if result, err := client.Do("SET", "hello", "again"); err != nil || result != OK {
+		t.Fatal(err, result)
+	}
+
+	if result, err := client.Do("GET", "hello"); err != nil || result == nil {
+		t.Fatal(err, result)
+	}
+}
+
+// fakeSentinel answers the commands sent by Sentinel with a fixed master and replica.
+func fakeSentinel(t *testing.T) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serve := func(conn net.Conn) {
+		defer conn.Close()
+
+		decoder := NewDecoder(conn)
+		for {
+			reply, err := decoder.Decode()
+			if err != nil {
+				return
+			}
+
+			args := reply.([]interface{})
+			switch strings.ToUpper(argText(args[0])) + " " + strings.ToLower(argText(args[len(args)-1])) {
+			case "SENTINEL test":
+				if strings.ToLower(argText(args[1])) == "replicas" {
+					conn.Write([]byte("*1\r\n*6\r\n$2\r\nip\r\n$9\r\n127.0.0.1\r\n$4\r\nport\r\n$1\r\n2\r\n$5\r\nflags\r\n$5\r\nslave\r\n"))
+				} else {
+					conn.Write([]byte("*2\r\n$9\r\n127.0.0.1\r\n$1\r\n1\r\n"))
+				}
+			default:
+				if strings.ToUpper(argText(args[0])) == "SUBSCRIBE" {
+					for i, channel := range args[1:] {
+						fmt.Fprintf(conn, "*3\r\n$9\r\nsubscribe\r\n$%d\r\n%s\r\n:%d\r\n", len(channel.([]byte)), channel, i+1)
+					}
+				} else {
+					conn.Write([]byte("+OK\r\n"))
+				}
+			}
+		}
+	}
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			go serve(conn)
+		}
+	}()
+
+	return l
+}
+
+func TestSentinelSubscription(t *testing.T) {
+	down, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	down.Close()
+
+	up := fakeSentinel(t)
+	defer up.Close()
+
+	s := &Sentinel{
+		Sentinels:       []string{"tcp://" + down.Addr().String(), "tcp://" + up.Addr().String()},
+		MasterName:      "test",
+		ReadFromReplica: true,
+	}
+
+	s.once.Do(s.initialize)
+	defer s.Close()
+
+	// the replicas are loaded once subscribed through the sentinel that is up
+	for i := 0; s.replica() == nil; i++ {
+		if i == 100 {
+			t.Fatal("replicas weren't loaded")
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if address := s.Address(); address != "127.0.0.1:1" {
+		t.Fatalf("unexpected master '%s'", address)
+	}
+}
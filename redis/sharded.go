@@ -0,0 +1,255 @@
+// Copyright (c) 2015 Datacratic. All rights reserved.
+
+package redis
+
+import (
+	"errors"
+	"log"
+	"sync"
+)
+
+// ShardedPubSub implements a subscriber to the shard channels of a Redis cluster.
+// Channels are grouped by the node owning their slot with one connection per node.
+// Subscriptions follow the slots when they move to another node.
+type ShardedPubSub struct {
+	// OnMessage is called for each message when set.
+	// Otherwise, messages are delivered on the channel returned by Messages.
+	OnMessage func(*Message)
+
+	client  *Client
+	unwatch func()
+
+	mu       sync.Mutex
+	channels map[string]*Conn
+	nodes    map[*Conn]*PubSub
+	closed   bool
+	wg       sync.WaitGroup
+
+	messages chan *Message
+	quit     chan struct{}
+}
+
+// ShardedPubSub creates a subscriber for shard channels i.e. SSUBSCRIBE.
+func (client *Client) ShardedPubSub() *ShardedPubSub {
+	sps := &ShardedPubSub{
+		client:   client,
+		channels: make(map[string]*Conn),
+		nodes:    make(map[*Conn]*PubSub),
+		messages: make(chan *Message, DefaultMessageBuffer),
+		quit:     make(chan struct{}),
+	}
+
+	sps.unwatch = client.watch(sps.rebalance)
+	return sps
+}
+
+// Messages returns the channel on which the messages of all nodes are delivered when OnMessage isn't set.
+// The channel is closed once the subscriber is closed.
+func (sps *ShardedPubSub) Messages() <-chan *Message {
+	return sps.messages
+}
+
+// SSubscribe subscribes to the specified shard channels.
+func (sps *ShardedPubSub) SSubscribe(channels ...string) (err error) {
+	state := sps.client.current()
+
+	sps.mu.Lock()
+	defer sps.mu.Unlock()
+
+	if sps.closed {
+		return ErrConnectionUnavailable
+	}
+
+	for _, channel := range channels {
+		if _, ok := sps.channels[channel]; ok {
+			continue
+		}
+
+		node := sps.owner(state, channel)
+		sps.channels[channel] = node
+		if e := sps.node(node).SSubscribe(channel); e != nil && err == nil {
+			err = e
+		}
+	}
+
+	return
+}
+
+// SUnsubscribe unsubscribes from the specified shard channels.
+func (sps *ShardedPubSub) SUnsubscribe(channels ...string) (err error) {
+	sps.mu.Lock()
+	defer sps.mu.Unlock()
+
+	for _, channel := range channels {
+		node, ok := sps.channels[channel]
+		if !ok {
+			continue
+		}
+
+		delete(sps.channels, channel)
+		if e := sps.node(node).SUnsubscribe(channel); e != nil && err == nil {
+			err = e
+		}
+	}
+
+	sps.prune()
+	return
+}
+
+// Close tears down the connections to all nodes.
+func (sps *ShardedPubSub) Close() {
+	sps.mu.Lock()
+	if sps.closed {
+		sps.mu.Unlock()
+		return
+	}
+
+	sps.closed = true
+	close(sps.quit)
+
+	for node, ps := range sps.nodes {
+		sps.close(ps)
+		delete(sps.nodes, node)
+	}
+
+	sps.mu.Unlock()
+
+	// stop following the slots
+	sps.unwatch()
+
+	// wait for all nodes to stop delivering messages
+	sps.wg.Wait()
+	close(sps.messages)
+}
+
+// close tears down a subscriber in the background since it may be delivering a message.
+func (sps *ShardedPubSub) close(ps *PubSub) {
+	sps.wg.Add(1)
+	go func() {
+		ps.Close()
+		sps.wg.Done()
+	}()
+}
+
+// owner returns the node serving the slot of the channel.
+func (sps *ShardedPubSub) owner(state *mapping, channel string) *Conn {
+	if !state.shards {
+		return state.slots[0]
+	}
+
+	return state.slots[slot([]byte(channel))]
+}
+
+// node returns the subscriber connected to the node; the lock must be held.
+func (sps *ShardedPubSub) node(node *Conn) *PubSub {
+	ps, ok := sps.nodes[node]
+	if ok {
+		return ps
+	}
+
+	ps = node.PubSub()
+	ps.OnMessage = func(message *Message) {
+		sps.deliver(node, message)
+	}
+
+	ps.onError = sps.redirected
+	sps.nodes[node] = ps
+	return ps
+}
+
+// prune closes the subscribers left without channels; the lock must be held.
+func (sps *ShardedPubSub) prune() {
+	used := make(map[*Conn]bool)
+	for _, node := range sps.channels {
+		used[node] = true
+	}
+
+	for node, ps := range sps.nodes {
+		if !used[node] {
+			sps.close(ps)
+			delete(sps.nodes, node)
+		}
+	}
+}
+
+// rebalance moves the subscriptions whose slot is now served by another node.
+func (sps *ShardedPubSub) rebalance() {
+	state := sps.client.state.Load().(*mapping)
+	if state.closed {
+		return
+	}
+
+	sps.mu.Lock()
+	defer sps.mu.Unlock()
+
+	if sps.closed {
+		return
+	}
+
+	for channel, last := range sps.channels {
+		next := sps.owner(state, channel)
+		if next == last {
+			continue
+		}
+
+		sps.node(last).SUnsubscribe(channel)
+		sps.channels[channel] = next
+		sps.node(next).SSubscribe(channel)
+	}
+
+	sps.prune()
+}
+
+// redirected asks for the mapping of slots to be reloaded when a node doesn't serve a channel anymore.
+func (sps *ShardedPubSub) redirected(err error) {
+	var moved *MovedError
+	if errors.As(err, &moved) {
+		sps.reload()
+	}
+}
+
+// reload asks for the mapping of slots to be reloaded in the background.
+// A client that didn't migrate to the cluster yet has no refresher so it migrates instead.
+func (sps *ShardedPubSub) reload() {
+	state, ok := sps.client.state.Load().(*mapping)
+	if !ok || state.closed {
+		return
+	}
+
+	if state.shards {
+		sps.client.refreshSoon()
+		return
+	}
+
+	go func() {
+		if _, err := sps.client.migrate(); err != nil {
+			log.Println("cluster migration error:", err)
+		}
+	}()
+}
+
+func (sps *ShardedPubSub) deliver(node *Conn, message *Message) {
+	// the node stopped serving the channel e.g. after its slot was migrated
+	// unless the channel was already moved to another node
+	if message.Kind == "sunsubscribe" {
+		sps.mu.Lock()
+		owner, ok := sps.channels[message.Channel]
+		sps.mu.Unlock()
+
+		if ok && owner == node {
+			sps.reload()
+		}
+
+		return
+	}
+
+	if sps.OnMessage != nil {
+		sps.OnMessage(message)
+		return
+	}
+
+	select {
+	case sps.messages <- message:
+	case <-sps.quit:
+	}
+}
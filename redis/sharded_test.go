@@ -0,0 +1,112 @@
+// Copyright (c) 2015 Datacratic. All rights reserved.
+
+package redis
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestShardedUnsubscribed(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer l.Close()
+
+	// a single node cluster serving all slots that counts how many times its topology is loaded
+	var loads int32
+	port := l.Addr().(*net.TCPAddr).Port
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				defer c.Close()
+
+				decoder := NewDecoder(c)
+				for {
+					cmd, err := decoder.Decode()
+					if err != nil {
+						return
+					}
+
+					args, _ := cmd.([]interface{})
+					name, _ := args[0].([]byte)
+
+					switch strings.ToUpper(string(name)) {
+					case "CLUSTER":
+						atomic.AddInt32(&loads, 1)
+						fmt.Fprintf(c, "*1\r\n*3\r\n:0\r\n:16383\r\n*2\r\n$9\r\n127.0.0.1\r\n:%d\r\n", port)
+					case "COMMAND":
+						c.Write([]byte("-ERR unknown command\r\n"))
+					default:
+						c.Write([]byte("+OK\r\n"))
+					}
+				}
+			}()
+		}
+	}()
+
+	wait := func(what string, f func() bool) {
+		for deadline := time.Now().Add(time.Second); !f(); time.Sleep(time.Millisecond) {
+			if time.Now().After(deadline) {
+				t.Fatal(what)
+			}
+		}
+	}
+
+	client := &Client{
+		Address: []string{fmt.Sprintf("tcp://127.0.0.1:%d", port)},
+	}
+
+	defer client.Close()
+
+	sps := client.ShardedPubSub()
+	defer sps.Close()
+
+	a, b := &Conn{}, client.current().slots[0]
+
+	sps.mu.Lock()
+	sps.channels["news"] = b
+	sps.mu.Unlock()
+
+	// confirmation of a channel moved from a to b
+	sps.deliver(a, &Message{Kind: "sunsubscribe", Channel: "news"})
+	time.Sleep(10 * time.Millisecond)
+	if n := atomic.LoadInt32(&loads); n != 0 {
+		t.Fatal("refresh requested for a channel already moved")
+	}
+
+	// b doesn't serve the channel anymore which makes the fresh client load the slots
+	sps.deliver(b, &Message{Kind: "sunsubscribe", Channel: "news"})
+	wait("slots weren't loaded", func() bool {
+		return client.state.Load().(*mapping).shards
+	})
+
+	// the slots are loaded again by the refresher once migrated
+	n := atomic.LoadInt32(&loads)
+	sps.deliver(b, &Message{Kind: "sunsubscribe", Channel: "news"})
+	wait("slots weren't reloaded", func() bool {
+		return atomic.LoadInt32(&loads) > n
+	})
+}
+
+func TestShardedClose(t *testing.T) {
+	client := &Client{}
+	for i := 0; i < 2; i++ {
+		client.ShardedPubSub().Close()
+	}
+
+	if n := len(client.listeners); n != 0 {
+		t.Fatalf("closed subscribers still follow the slots %d", n)
+	}
+}
@@ -0,0 +1,152 @@
+// Copyright (c) 2015 Datacratic. All rights reserved.
+
+package redis
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// splittable lists the multi-key commands that can be split per slot with the number of arguments per key.
+var splittable = map[string]int{
+	"MGET":   1,
+	"MSET":   2,
+	"DEL":    1,
+	"EXISTS": 1,
+	"UNLINK": 1,
+	"TOUCH":  1,
+}
+
+// part holds the keys of a multi-key command that belong to the same slot.
+type part struct {
+	request *Request
+
+	// index gives the position of each key of the part in the original command.
+	index []int
+	err   error
+}
+
+// split divides a request made of a single multi-key command into one request per slot.
+// It returns nothing if the keys already belong to the same slot or the command can't be split.
+func (request *Request) split() []*part {
+	return request.splitBy(slot)
+}
+
+// splitBy divides a request made of a single multi-key command into one request per group of keys.
+func (request *Request) splitBy(group func(key []byte) int) (parts []*part) {
+	if len(request.commands) != 1 {
+		return
+	}
+
+	cmd := &request.commands[0]
+	step, ok := splittable[strings.ToUpper(cmd.name)]
+	if !ok || len(cmd.args) == 0 || len(cmd.args)%step != 0 {
+		return
+	}
+
+	groups := make(map[int]*part)
+	for i := 0; i < len(cmd.args); i += step {
+		key, ok := keyBytes(cmd.args[i])
+		if !ok {
+			return nil
+		}
+
+		h := group(key)
+		p := groups[h]
+		if p == nil {
+			p = &part{
+				request: &Request{
+					commands: []command{
+						command{
+							name: cmd.name,
+						},
+					},
+				},
+			}
+
+			groups[h] = p
+			parts = append(parts, p)
+		}
+
+		c := &p.request.commands[0]
+		c.args = append(c.args, cmd.args[i:i+step]...)
+		p.index = append(p.index, i/step)
+	}
+
+	if len(parts) < 2 {
+		parts = nil
+	}
+
+	return
+}
+
+// sendParts sends the parts of a split request in parallel and merges their replies in the original order.
+// Integer replies are summed which gives the number of keys deleted or found.
+func sendParts(ctx context.Context, s ContextSender, request *Request, parts []*part) error {
+	var wg sync.WaitGroup
+	for _, p := range parts {
+		wg.Add(1)
+		go func(p *part) {
+			p.err = s.SendContext(ctx, p.request)
+			wg.Done()
+		}(p)
+	}
+
+	wg.Wait()
+
+	cmd := &request.commands[0]
+	cmd.result, cmd.err = nil, nil
+
+	var items []interface{}
+	var sum int64
+
+	n := 0
+	for _, p := range parts {
+		n += len(p.index)
+	}
+
+	for _, p := range parts {
+		if p.err != nil {
+			cmd.err = p.err
+			break
+		}
+
+		switch reply := p.request.commands[0].result.(type) {
+		case []interface{}:
+			if len(reply) != len(p.index) {
+				cmd.err = fmt.Errorf("redis returned %d values for %d keys", len(reply), len(p.index))
+				break
+			}
+
+			if items == nil {
+				items = make([]interface{}, n)
+			}
+
+			for j, k := range p.index {
+				items[k] = reply[j]
+			}
+		case int64:
+			sum += reply
+			cmd.result = sum
+		default:
+			cmd.result = reply
+		}
+
+		if cmd.err != nil {
+			break
+		}
+	}
+
+	if items != nil && cmd.err == nil {
+		cmd.result = items
+	}
+
+	if cmd.err != nil {
+		cmd.result = nil
+	}
+
+	request.err = cmd.err
+	return request.err
+}
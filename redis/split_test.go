@@ -0,0 +1,31 @@
+// Copyright (c) 2015 Datacratic. All rights reserved.
+
+package redis
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplit(t *testing.T) {
+	if parts := NewRequest("MGET", "{a}1", "{a}2").split(); parts != nil {
+		t.Fatalf("unexpected split of keys of the same slot")
+	}
+
+	if parts := NewRequest("GET", "a").split(); parts != nil {
+		t.Fatalf("unexpected split of a single key command")
+	}
+
+	parts := NewRequest("MSET", "{a}1", 1, "{b}1", 2, "{a}2", 3).split()
+	if len(parts) != 2 {
+		t.Fatalf("unexpected number of parts %d", len(parts))
+	}
+
+	if args := parts[0].request.Args(0); !reflect.DeepEqual(args, []interface{}{"{a}1", 1, "{a}2", 3}) || !reflect.DeepEqual(parts[0].index, []int{0, 2}) {
+		t.Fatal(args, parts[0].index)
+	}
+
+	if args := parts[1].request.Args(0); !reflect.DeepEqual(args, []interface{}{"{b}1", 2}) || !reflect.DeepEqual(parts[1].index, []int{1}) {
+		t.Fatal(args, parts[1].index)
+	}
+}
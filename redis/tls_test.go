@@ -0,0 +1,178 @@
+// Copyright (c) 2015 Datacratic. All rights reserved.
+
+package redis
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	mathrand "math/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// newTestTLSDB creates a temporary Redis database instance that also accepts TLS connections on a local port.
+// Certificates are generated for the local host and trusted by the connections returned by dialTLS.
+func newTestTLSDB() (result *DB, err error) {
+	dir, err := ioutil.TempDir("", "redis")
+	if err != nil {
+		return
+	}
+
+	defer func() {
+		if err != nil {
+			os.RemoveAll(dir)
+		}
+	}()
+
+	config, client, err := newTestCertificates(dir)
+	if err != nil {
+		return
+	}
+
+	port, err := freePort()
+	if err != nil {
+		return
+	}
+
+	config["port"] = "0"
+	config["dir"] = dir
+	config["tls-port"] = fmt.Sprintf("%d", port)
+
+	result, err = New("", config)
+	if err != nil {
+		return
+	}
+
+	result.data = dir
+	result.tls = client
+	result.addr = fmt.Sprintf("127.0.0.1:%d", port)
+	return
+}
+
+// dialTLS connects to the Redis database instance over TLS.
+// The instance must have been created by newTestTLSDB.
+func (db *DB) dialTLS() *Conn {
+	return DialWithOptions("tcp", db.addr, DialOptions{
+		TLSConfig: db.tls,
+	})
+}
+
+// newTestTLSCluster creates a local Redis test cluster of the specified size at a random port that only accepts TLS connections.
+func newTestTLSCluster(size int) (result *Cluster, err error) {
+	root, err := ioutil.TempDir("", "redis-cluster")
+	if err != nil {
+		return
+	}
+
+	config, client, err := newTestCertificates(root)
+	if err != nil {
+		return
+	}
+
+	// allocate a base port at random
+	port := mathrand.New(mathrand.NewSource(time.Now().UnixNano())).Intn(10000) + 10000
+
+	result, err = NewCluster(size, port, root, config)
+	if err != nil {
+		return
+	}
+
+	result.test = true
+	result.tls = client
+	return
+}
+
+// newTestCertificates generates a certificate authority and a certificate for the local host under 'dir'.
+// It returns the Redis configuration required to accept TLS connections and the client configuration that trusts them.
+func newTestCertificates(dir string) (config map[string]string, client *tls.Config, err error) {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return
+	}
+
+	now := time.Now()
+	ca := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "goredis test CA"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	caDER, err := x509.CreateCertificate(rand.Reader, ca, ca, &caKey.PublicKey, caKey)
+	if err != nil {
+		return
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return
+	}
+
+	cert := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+
+	certDER, err := x509.CreateCertificate(rand.Reader, cert, ca, &key.PublicKey, caKey)
+	if err != nil {
+		return
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return
+	}
+
+	files := map[string]*pem.Block{
+		"ca.crt":    &pem.Block{Type: "CERTIFICATE", Bytes: caDER},
+		"redis.crt": &pem.Block{Type: "CERTIFICATE", Bytes: certDER},
+		"redis.key": &pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER},
+	}
+
+	for name, block := range files {
+		err = ioutil.WriteFile(filepath.Join(dir, name), pem.EncodeToMemory(block), 0600)
+		if err != nil {
+			return
+		}
+	}
+
+	config = map[string]string{
+		"tls-cert-file":    filepath.Join(dir, "redis.crt"),
+		"tls-key-file":     filepath.Join(dir, "redis.key"),
+		"tls-ca-cert-file": filepath.Join(dir, "ca.crt"),
+		"tls-auth-clients": "no",
+	}
+
+	root, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		return
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(root)
+
+	client = &tls.Config{
+		RootCAs: pool,
+	}
+
+	return
+}
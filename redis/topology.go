@@ -0,0 +1,240 @@
+// Copyright (c) 2015 Datacratic. All rights reserved.
+
+package redis
+
+import (
+	"fmt"
+	"log"
+	"math/rand"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DefaultMinimumRefreshInterval defines the default minimum delay between two reloads of the topology of a cluster.
+var DefaultMinimumRefreshInterval = time.Second
+
+// DefaultRefreshNodes defines the default number of nodes asked for the topology of a cluster.
+var DefaultRefreshNodes = 3
+
+// TopologyEvent describes a change of the topology of a cluster.
+// Nodes are named by their URL.
+type TopologyEvent struct {
+	Added   []string
+	Removed []string
+	Moved   int
+}
+
+// startRefresher starts reloading the topology in the background once the client knows it talks to a cluster.
+func (client *Client) startRefresher() {
+	if client.quit != nil {
+		return
+	}
+
+	client.trigger = make(chan struct{}, 1)
+	client.quit = make(chan struct{})
+	go client.refresher(client.trigger, client.quit)
+}
+
+// refreshSoon asks the background refresher to reload the topology.
+func (client *Client) refreshSoon() {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+
+	select {
+	case client.trigger <- struct{}{}:
+	default:
+	}
+}
+
+func (client *Client) refresher(trigger <-chan struct{}, quit <-chan struct{}) {
+	var tick <-chan time.Time
+	if client.RefreshInterval > 0 {
+		ticker := time.NewTicker(client.RefreshInterval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	limit := client.MinimumRefreshInterval
+	if 0 == limit {
+		limit = DefaultMinimumRefreshInterval
+	}
+
+	var last time.Time
+	for {
+		select {
+		case <-tick:
+		case <-trigger:
+		case <-quit:
+			return
+		}
+
+		// rate limit reloads triggered by a burst of failures
+		if wait := limit - time.Since(last); wait > 0 {
+			select {
+			case <-time.After(wait):
+			case <-quit:
+				return
+			}
+		}
+
+		last = time.Now()
+		if err := client.refresh(); err != nil {
+			log.Println("cluster topology refresh error:", err)
+		}
+	}
+}
+
+// refresh reloads the mapping of slots from the view shared by most of the nodes asked.
+func (client *Client) refresh() (err error) {
+	client.mu.Lock()
+	candidates := make([]*Conn, 0, len(client.nodes))
+	if state, ok := client.state.Load().(*mapping); ok && !state.closed && len(state.nodes) != 0 {
+		for _, node := range state.nodes {
+			candidates = append(candidates, node)
+		}
+	} else {
+		for _, node := range client.nodes {
+			candidates = append(candidates, node)
+		}
+	}
+
+	client.mu.Unlock()
+
+	n := client.RefreshNodes
+	if 0 == n {
+		n = DefaultRefreshNodes
+	}
+
+	rand.Shuffle(len(candidates), func(i, j int) {
+		candidates[i], candidates[j] = candidates[j], candidates[i]
+	})
+
+	if len(candidates) > n {
+		candidates = candidates[:n]
+	}
+
+	type view struct {
+		result interface{}
+		err    error
+	}
+
+	done := make(chan view, len(candidates))
+	for _, node := range candidates {
+		go func(node *Conn) {
+			result, err := node.Do("CLUSTER", "SLOTS")
+			done <- view{result, err}
+		}(node)
+	}
+
+	// pick the view returned by most nodes
+	votes := make(map[string]int)
+	var best interface{}
+	most := 0
+
+	err = fmt.Errorf("no node available")
+	for range candidates {
+		v := <-done
+		if v.err != nil {
+			err = v.err
+			continue
+		}
+
+		key := fingerprint(v.result)
+		votes[key]++
+		if votes[key] > most {
+			best, most = v.result, votes[key]
+		}
+	}
+
+	if best == nil {
+		return
+	}
+
+	client.mu.Lock()
+	defer client.mu.Unlock()
+
+	state := client.state.Load().(*mapping)
+	if state.closed {
+		return fmt.Errorf("client closed")
+	}
+
+	_, err = client.apply(state, best)
+	return
+}
+
+// fingerprint returns a canonical description of the masters given by CLUSTER SLOTS to compare views.
+func fingerprint(result interface{}) string {
+	groups, _ := result.([]interface{})
+
+	var ranges []string
+	for _, group := range groups {
+		item, ok := group.([]interface{})
+		if !ok || len(item) < 3 {
+			continue
+		}
+
+		m, _ := item[2].([]interface{})
+		if len(m) < 2 {
+			continue
+		}
+
+		ranges = append(ranges, fmt.Sprintf("%v-%v@%s:%v", item[0], item[1], m[0], m[1]))
+	}
+
+	sort.Strings(ranges)
+	return strings.Join(ranges, ",")
+}
+
+// prune closes the connections to the nodes that left the cluster and publishes the changes.
+func (client *Client) prune(last, next *mapping) {
+	event := TopologyEvent{}
+
+	for name := range next.nodes {
+		if _, ok := last.nodes[name]; !ok && last.shards {
+			event.Added = append(event.Added, name)
+		}
+	}
+
+	for name := range last.nodes {
+		if _, ok := next.nodes[name]; !ok {
+			event.Removed = append(event.Removed, name)
+		}
+	}
+
+	if last.shards {
+		for i := range next.slots {
+			if next.slots[i] != last.slots[i] {
+				event.Moved++
+			}
+		}
+	}
+
+	// seeds are kept to find the cluster again and so are the nodes still asked
+	for name, node := range client.nodes {
+		if _, ok := next.nodes[name]; !ok && !client.seeds[name] && client.asked[node] == 0 {
+			delete(client.nodes, name)
+			go node.Close()
+		}
+	}
+
+	used := make(map[*Conn]bool)
+	for _, replicas := range next.replicas {
+		for _, node := range replicas {
+			used[node] = true
+		}
+	}
+
+	for name, node := range client.replicas {
+		if !used[node] {
+			delete(client.replicas, name)
+			go node.Close()
+		}
+	}
+
+	if client.OnTopologyChange != nil && (len(event.Added) != 0 || len(event.Removed) != 0 || event.Moved != 0) {
+		sort.Strings(event.Added)
+		sort.Strings(event.Removed)
+		go client.OnTopologyChange(event)
+	}
+}
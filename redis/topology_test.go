@@ -0,0 +1,215 @@
+// Copyright (c) 2015 Datacratic. All rights reserved.
+
+package redis
+
+import (
+	"io"
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRefresh(t *testing.T) {
+	view := func(port string) string {
+		return "*1\r\n*3\r\n:0\r\n:16383\r\n*2\r\n$9\r\n127.0.0.1\r\n:" + port + "\r\n"
+	}
+
+	events := make(chan TopologyEvent, 1)
+	client := &Client{
+		OnTopologyChange: func(event TopologyEvent) {
+			events <- event
+		},
+		scheme:   "tcp",
+		nodes:    make(map[string]*Conn),
+		replicas: make(map[string]*Conn),
+	}
+
+	state := &mapping{
+		shards: true,
+		nodes:  make(map[string]*Conn),
+	}
+
+	// two nodes out of three agree on the topology
+	for name, port := range map[string]string{
+		"tcp://127.0.0.1:7000": "7001",
+		"tcp://127.0.0.1:7001": "7001",
+		"tcp://127.0.0.1:7002": "7002",
+	} {
+		db := new(mockDB)
+		db.result.WriteString(view(port))
+		conn := &Conn{db: db}
+		client.nodes[name] = conn
+		state.nodes[name] = conn
+	}
+
+	for i := range state.slots {
+		state.slots[i] = state.nodes["tcp://127.0.0.1:7000"]
+	}
+
+	client.state.Store(state)
+	defer client.Close()
+
+	if err := client.refresh(); err != nil {
+		t.Fatal(err)
+	}
+
+	next := client.current()
+	if next.slots[0] != state.nodes["tcp://127.0.0.1:7001"] || len(next.nodes) != 1 {
+		t.Fatalf("unexpected topology '%v'", next.nodes)
+	}
+
+	select {
+	case event := <-events:
+		expected := TopologyEvent{
+			Removed: []string{"tcp://127.0.0.1:7000", "tcp://127.0.0.1:7002"},
+			Moved:   16384,
+		}
+
+		if !reflect.DeepEqual(event, expected) {
+			t.Fatalf("unexpected event '%+v' instead of '%+v'", event, expected)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no topology event")
+	}
+
+	// departed nodes are closed
+	for i := 0; state.nodes["tcp://127.0.0.1:7002"].State() != StateClosed; i++ {
+		if i == 100 {
+			t.Fatal("departed node wasn't closed")
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if _, ok := client.nodes["tcp://127.0.0.1:7002"]; ok {
+		t.Fatal("departed node is still known")
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	a := []interface{}{
+		[]interface{}{int64(0), int64(100), []interface{}{[]byte("127.0.0.1"), int64(7000)}},
+		[]interface{}{int64(101), int64(16383), []interface{}{[]byte("127.0.0.1"), int64(7001)}, []interface{}{[]byte("127.0.0.1"), int64(7002)}},
+	}
+
+	b := []interface{}{a[1], a[0]}
+	if fingerprint(a) != fingerprint(b) {
+		t.Fatal("the order of slots doesn't matter")
+	}
+
+	c := []interface{}{a[0]}
+	if fingerprint(a) == fingerprint(c) {
+		t.Fatal("missing slots must differ")
+	}
+}
+
+func TestPruneAsked(t *testing.T) {
+	client := &Client{
+		Address: []string{"tcp://127.0.0.1:7000"},
+	}
+
+	client.once.Do(client.initialize)
+	defer client.Close()
+
+	last := client.current()
+
+	// the mapping doesn't share the nodes of the client
+	target := client.lookup("127.0.0.1:7001")
+	if _, ok := last.nodes["tcp://127.0.0.1:7001"]; ok {
+		t.Fatal("the mapping was changed by an ASK")
+	}
+
+	next := &mapping{
+		shards: true,
+		nodes: map[string]*Conn{
+			"tcp://127.0.0.1:7002": client.lookup("127.0.0.1:7002"),
+		},
+	}
+
+	client.release(next.nodes["tcp://127.0.0.1:7002"])
+
+	// the target of an ASK in progress is kept
+	client.prune(last, next)
+	if client.nodes["tcp://127.0.0.1:7001"] != target || target.State() == StateClosed {
+		t.Fatal("asked node was pruned")
+	}
+
+	client.release(target)
+	client.prune(last, next)
+	if _, ok := client.nodes["tcp://127.0.0.1:7001"]; ok {
+		t.Fatal("released node wasn't pruned")
+	}
+
+	// seeds are kept
+	if _, ok := client.nodes["tcp://127.0.0.1:7000"]; !ok {
+		t.Fatal("seed was pruned")
+	}
+}
+
+func TestPruneInUse(t *testing.T) {
+	// the node replies to every command until it is closed
+	node := &Conn{
+		db: dialerFunc(func() (net.Conn, error) {
+			client, server := net.Pipe()
+			go func() {
+				decoder := NewDecoder(server)
+				for {
+					if _, err := decoder.Decode(); err != nil {
+						return
+					}
+
+					server.Write([]byte("+OK\r\n"))
+				}
+			}()
+
+			return client, nil
+		}),
+	}
+
+	client := &Client{
+		nodes: map[string]*Conn{
+			"tcp://127.0.0.1:7001": node,
+		},
+		replicas: make(map[string]*Conn),
+	}
+
+	last := &mapping{
+		shards: true,
+		nodes:  client.nodes,
+	}
+
+	// requests keep being sent to the node of the last mapping while it leaves the cluster
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				if _, err := node.Do("PING"); err != nil {
+					if err != ErrConnectionUnavailable && err != io.ErrClosedPipe {
+						t.Error(err)
+					}
+
+					return
+				}
+			}
+		}()
+	}
+
+	time.Sleep(10 * time.Millisecond)
+
+	client.mu.Lock()
+	client.prune(last, &mapping{shards: true})
+	client.mu.Unlock()
+
+	wg.Wait()
+
+	// the node is closed in the background
+	for deadline := time.Now().Add(time.Second); node.State() != StateClosed; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("node wasn't closed")
+		}
+	}
+}
@@ -0,0 +1,242 @@
+// Copyright (c) 2015 Datacratic. All rights reserved.
+
+package redis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// DefaultMaximumTransactionRetries defines the default number of times a transaction is retried when its watched keys changed.
+var DefaultMaximumTransactionRetries = 8
+
+// Tx holds the commands queued by a transaction along with the connection pinned while it runs.
+type Tx struct {
+	link    *link
+	timeout time.Duration
+
+	// slot is the hash slot all keys must belong to or -1 when not sent to a cluster.
+	slot     int
+	commands CommandTable
+
+	queued []command
+	err    error
+}
+
+// Do executes the specified command right away on the connection of the transaction e.g. to read the watched keys.
+func (tx *Tx) Do(name string, args ...interface{}) (result interface{}, err error) {
+	request := NewRequest(name, args...)
+	if err = tx.link.roundTrip(request, tx.timeout); err == nil {
+		result = request.commands[0].result
+	}
+
+	return
+}
+
+// Watch watches additional keys so that the transaction is aborted and retried if they change before it executes.
+func (tx *Tx) Watch(keys ...string) (err error) {
+	args := make([]interface{}, len(keys))
+	for i, key := range keys {
+		if err = tx.check([]byte(key)); err != nil {
+			return
+		}
+
+		args[i] = key
+	}
+
+	_, err = tx.Do("WATCH", args...)
+	return
+}
+
+// Queue appends the specified command to the ones executed atomically once the transaction function returns.
+func (tx *Tx) Queue(name string, args ...interface{}) {
+	cmd := command{
+		name: name,
+		args: args,
+	}
+
+	for _, key := range cmd.keys(tx.commands) {
+		if tx.err == nil {
+			tx.err = tx.check(key)
+		}
+	}
+
+	tx.queued = append(tx.queued, cmd)
+}
+
+func (tx *Tx) check(key []byte) error {
+	if tx.slot >= 0 && slot(key) != tx.slot {
+		return ErrCrossSlot
+	}
+
+	return nil
+}
+
+// exec sends the queued commands wrapped with MULTI/EXEC.
+// It returns no result without error when the transaction was aborted.
+func (tx *Tx) exec() (result *Request, err error) {
+	if err = tx.err; err != nil {
+		tx.Do("UNWATCH")
+		return
+	}
+
+	request := NewRequest("MULTI")
+	request.commands = append(request.commands, tx.queued...)
+	request.Add("EXEC")
+
+	// errors found while queuing commands come before the EXECABORT of EXEC
+	if err = tx.link.roundTrip(request, tx.timeout); err != nil {
+		return
+	}
+
+	reply := request.commands[len(request.commands)-1].result
+	if reply == nil {
+		return
+	}
+
+	items, ok := reply.([]interface{})
+	if !ok || len(items) != len(tx.queued) {
+		err = fmt.Errorf("redis returned an invalid reply to EXEC '%v'", reply)
+		return
+	}
+
+	result = &Request{
+		commands: tx.queued,
+	}
+
+	for i, item := range items {
+		if e, ok := item.(error); ok {
+			result.commands[i].err = e
+		} else {
+			result.commands[i].result = item
+		}
+	}
+
+	return
+}
+
+func keyBytes(arg interface{}) (key []byte, ok bool) {
+	switch value := arg.(type) {
+	case string:
+		return []byte(value), true
+	case []byte:
+		return value, true
+	}
+
+	return
+}
+
+// Tx watches the specified keys then calls the function on a connection pinned for the transaction.
+// The commands queued by the function are executed atomically with MULTI/EXEC and their replies are available with Result on the returned request.
+// The function is called again when the watched keys changed in the meantime so it must not have other side effects.
+// ErrTxAborted is returned once MaximumTransactionRetries is exceeded.
+func (conn *Conn) Tx(ctx context.Context, f func(*Tx) error, keys ...string) (result *Request, err error) {
+	return conn.tx(ctx, f, -1, nil, keys)
+}
+
+func (conn *Conn) tx(ctx context.Context, f func(*Tx) error, slot int, commands CommandTable, keys []string) (result *Request, err error) {
+	retries := conn.MaximumTransactionRetries
+	if 0 == retries {
+		retries = DefaultMaximumTransactionRetries
+	}
+
+	err = conn.dedicated(ctx, func(l *link) (err error) {
+		for i := 0; i <= retries; i++ {
+			tx := &Tx{
+				link:     l,
+				timeout:  conn.Options.ReadTimeout,
+				slot:     slot,
+				commands: commands,
+			}
+
+			if len(keys) != 0 {
+				if err = tx.Watch(keys...); err != nil {
+					return
+				}
+			}
+
+			if err = f(tx); err != nil {
+				tx.Do("UNWATCH")
+				return
+			}
+
+			if result, err = tx.exec(); err != nil || result != nil {
+				return
+			}
+		}
+
+		return ErrTxAborted
+	})
+
+	return
+}
+
+// Tx runs a transaction on the node owning the slot of the specified keys which must all belong to the same slot.
+// On a cluster, the keys of the queued commands must belong to it as well otherwise ErrCrossSlot is returned.
+// See Conn.Tx for details.
+func (client *Client) Tx(ctx context.Context, f func(*Tx) error, keys ...string) (result *Request, err error) {
+	if len(keys) == 0 {
+		err = fmt.Errorf("redis transaction requires a key to be routed")
+		return
+	}
+
+	state := client.current()
+
+	hash := slot([]byte(keys[0]))
+	for _, key := range keys[1:] {
+		if slot([]byte(key)) != hash {
+			err = ErrCrossSlot
+			return
+		}
+	}
+
+	check := -1
+
+	redirect := client.MaximumRedirections
+	if 0 == redirect {
+		redirect = DefaultMaximumRedirections
+	}
+
+	for i := 0; i < redirect; i++ {
+		node := state.slots[0]
+		if state.shards {
+			node = state.slots[hash]
+			check = hash
+		}
+
+		if node == nil {
+			err = ErrConnectionUnavailable
+			return
+		}
+
+		result, err = node.tx(ctx, f, check, state.commands, keys)
+
+		var moved *MovedError
+		if !errors.As(err, &moved) || ctx.Err() != nil {
+			return
+		}
+
+		// follow the slot to its new owner
+		if !state.shards {
+			if state, err = client.migrate(); err != nil {
+				return
+			}
+
+			continue
+		}
+
+		if node = state.nodes[client.url(moved.Address)]; node != nil {
+			state, err = client.update(hash, node)
+		} else {
+			state, _, err = client.redirect(moved.Address)
+		}
+
+		if err != nil {
+			return
+		}
+	}
+
+	return
+}
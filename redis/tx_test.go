@@ -0,0 +1,98 @@
+// Copyright (c) 2015 Datacratic. All rights reserved.
+
+package redis
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestTx(t *testing.T) {
+	servers := make(chan net.Conn, 2)
+	conn := &Conn{
+		db: dialerFunc(func() (net.Conn, error) {
+			client, server := net.Pipe()
+			servers <- server
+			return client, nil
+		}),
+	}
+
+	defer conn.Close()
+
+	expect := func(decoder *Decoder, commands ...string) {
+		for _, name := range commands {
+			result, err := decoder.Decode()
+			if items, ok := result.([]interface{}); err != nil || !ok || string(items[0].([]byte)) != name {
+				t.Errorf("unexpected command '%q' instead of '%s'", result, name)
+			}
+		}
+	}
+
+	go func() {
+		server := <-servers
+		decoder := NewDecoder(server)
+
+		// the first attempt is aborted because the watched key changed
+		for _, value := range []string{"1", "2"} {
+			expect(decoder, "WATCH")
+			server.Write([]byte("+OK\r\n"))
+			expect(decoder, "GET")
+			server.Write([]byte("$1\r\n" + value + "\r\n"))
+			expect(decoder, "MULTI", "SET", "LPUSH", "EXEC")
+			if value == "1" {
+				server.Write([]byte("+OK\r\n+QUEUED\r\n+QUEUED\r\n*-1\r\n"))
+			} else {
+				server.Write([]byte("+OK\r\n+QUEUED\r\n+QUEUED\r\n*2\r\n+OK\r\n-WRONGTYPE Operation against a key holding the wrong kind of value\r\n"))
+			}
+		}
+	}()
+
+	calls := 0
+	result, err := conn.Tx(context.Background(), func(tx *Tx) error {
+		calls++
+		reply, err := tx.Do("GET", "counter")
+		if err != nil {
+			return err
+		}
+
+		n, _ := strconv.Atoi(string(reply.([]byte)))
+		tx.Queue("SET", "counter", n+1)
+		tx.Queue("LPUSH", "counter", "oops")
+		return nil
+	}, "counter")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if calls != 2 {
+		t.Fatalf("unexpected number of calls %d", calls)
+	}
+
+	if reply, err := result.Result(0); err != nil || !reflect.DeepEqual(reply, OK) {
+		t.Fatal(err, reply)
+	}
+
+	if reply, err := result.Result(1); !IsWrongType(err) {
+		t.Fatal(err, reply)
+	}
+}
+
+func TestTxCrossSlot(t *testing.T) {
+	tx := &Tx{
+		slot: slot([]byte("{user}a")),
+	}
+
+	tx.Queue("SET", "{user}b", 1)
+	if tx.err != nil {
+		t.Fatal(tx.err)
+	}
+
+	tx.Queue("SET", "other", 1)
+	if tx.err != ErrCrossSlot {
+		t.Fatal(tx.err)
+	}
+}
@@ -0,0 +1,252 @@
+// Copyright (c) 2015 Datacratic. All rights reserved.
+
+package streams
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/datacratic/goredis/redis"
+)
+
+// DefaultCount defines the default maximum number of entries read at once.
+var DefaultCount = 10
+
+// DefaultBlock defines the default duration a read waits for new entries.
+var DefaultBlock = 5 * time.Second
+
+// DefaultMinIdle defines the default duration after which the pending entries of other consumers are reclaimed.
+var DefaultMinIdle = time.Minute
+
+// DefaultClaimInterval defines the default delay between two scans of the stale pending entries.
+var DefaultClaimInterval = 30 * time.Second
+
+// Consumer reads the entries of a stream as a member of a consumer group.
+// Entries are acknowledged once handled without error which provides at-least-once semantics:
+// entries that failed stay pending and are reclaimed once idle for MinIdle, by this consumer or another one.
+// Entries delivered MaximumDeliveries times are moved to the DeadLetter stream or dropped if none.
+type Consumer struct {
+	Stream            string
+	Group             string
+	Name              string
+	Handler           func(context.Context, *XMessage) error
+	Count             int
+	Block             time.Duration
+	MinIdle           time.Duration
+	ClaimInterval     time.Duration
+	MaximumDeliveries int64
+	DeadLetter        string
+
+	// Client is used to acknowledge and claim entries.
+	Client redis.ContextSender
+
+	// Reader is used for the blocking reads and defaults to Client.
+	// Connections of this package already send them over dedicated connections.
+	Reader redis.ContextSender
+}
+
+// Run creates the consumer group if needed and handles entries until the context is done.
+// Entries left pending by a previous run of the same consumer are handled first.
+func (c *Consumer) Run(ctx context.Context) (err error) {
+	if err = c.create(ctx); err != nil {
+		return
+	}
+
+	interval := c.ClaimInterval
+	if 0 == interval {
+		interval = DefaultClaimInterval
+	}
+
+	// start with the history of this consumer then switch to new entries
+	start := "0"
+	claimed := time.Now()
+
+	for ctx.Err() == nil {
+		if time.Since(claimed) >= interval {
+			claimed = time.Now()
+			if err = c.reclaim(ctx); err != nil {
+				break
+			}
+		}
+
+		var messages []XMessage
+		if messages, err = c.read(ctx, start); err != nil {
+			break
+		}
+
+		if start != ">" {
+			if len(messages) == 0 {
+				start = ">"
+				continue
+			}
+
+			start = messages[len(messages)-1].ID
+		}
+
+		for i := range messages {
+			if err = c.handle(ctx, &messages[i]); err != nil {
+				break
+			}
+		}
+	}
+
+	// graceful shutdown
+	if ctx.Err() != nil {
+		err = nil
+	}
+
+	return
+}
+
+func (c *Consumer) do(ctx context.Context, s redis.ContextSender, name string, args ...interface{}) (result interface{}, err error) {
+	request := redis.NewRequest(name, args...)
+	if err = request.SendContext(ctx, s); err != nil {
+		return
+	}
+
+	return request.Result(0)
+}
+
+func (c *Consumer) create(ctx context.Context) (err error) {
+	_, err = c.do(ctx, c.Client, "XGROUP", "CREATE", c.Stream, c.Group, "$", "MKSTREAM")
+	if redis.ErrorKind(err) == "BUSYGROUP" {
+		err = nil
+	}
+
+	return
+}
+
+func (c *Consumer) read(ctx context.Context, start string) (result []XMessage, err error) {
+	reader := c.Reader
+	if reader == nil {
+		reader = c.Client
+	}
+
+	count := c.Count
+	if 0 == count {
+		count = DefaultCount
+	}
+
+	args := []interface{}{"GROUP", c.Group, c.Name, "COUNT", count}
+
+	// only wait for new entries
+	if start == ">" {
+		block := c.Block
+		if 0 == block {
+			block = DefaultBlock
+		}
+
+		args = append(args, "BLOCK", int64(block/time.Millisecond))
+	}
+
+	args = append(args, "STREAMS", c.Stream, start)
+
+	reply, err := c.do(ctx, reader, "XREADGROUP", args...)
+	if err != nil {
+		return
+	}
+
+	streams, err := ParseStreams(reply)
+	if err != nil {
+		return
+	}
+
+	result = streams[c.Stream]
+	return
+}
+
+// handle calls the handler and acknowledges the entry when successful.
+func (c *Consumer) handle(ctx context.Context, m *XMessage) (err error) {
+	// entries deleted from the stream while pending can't be handled
+	if m.Values != nil {
+		if e := c.Handler(ctx, m); e != nil {
+			log.Printf("stream %s entry %s failed: %s\n", c.Stream, m.ID, e)
+			return
+		}
+	}
+
+	_, err = c.do(ctx, c.Client, "XACK", c.Stream, c.Group, m.ID)
+	return
+}
+
+// reclaim takes over the stale pending entries of the group and dead-letters the ones delivered too often.
+func (c *Consumer) reclaim(ctx context.Context) (err error) {
+	idle := c.MinIdle
+	if 0 == idle {
+		idle = DefaultMinIdle
+	}
+
+	count := c.Count
+	if 0 == count {
+		count = DefaultCount
+	}
+
+	ms := int64(idle / time.Millisecond)
+
+	reply, err := c.do(ctx, c.Client, "XPENDING", c.Stream, c.Group, "IDLE", ms, "-", "+", count)
+	if err != nil {
+		return
+	}
+
+	pending, err := ParsePending(reply)
+	if err != nil || len(pending) == 0 {
+		return
+	}
+
+	var retry, dead []interface{}
+	for _, p := range pending {
+		if c.MaximumDeliveries > 0 && p.Deliveries >= c.MaximumDeliveries {
+			dead = append(dead, p.ID)
+		} else {
+			retry = append(retry, p.ID)
+		}
+	}
+
+	claim := func(ids []interface{}) (result []XMessage, err error) {
+		if len(ids) == 0 {
+			return
+		}
+
+		args := append([]interface{}{c.Stream, c.Group, c.Name, ms}, ids...)
+		reply, err := c.do(ctx, c.Client, "XCLAIM", args...)
+		if err != nil {
+			return
+		}
+
+		return ParseMessages(reply)
+	}
+
+	messages, err := claim(dead)
+	if err != nil {
+		return
+	}
+
+	for i := range messages {
+		m := &messages[i]
+		if c.DeadLetter != "" && m.Values != nil {
+			args := append([]interface{}{c.DeadLetter, "*"}, m.Args()...)
+			if _, err = c.do(ctx, c.Client, "XADD", args...); err != nil {
+				return
+			}
+		} else {
+			log.Printf("stream %s entry %s dropped after %d deliveries\n", c.Stream, m.ID, c.MaximumDeliveries)
+		}
+
+		if _, err = c.do(ctx, c.Client, "XACK", c.Stream, c.Group, m.ID); err != nil {
+			return
+		}
+	}
+
+	if messages, err = claim(retry); err != nil {
+		return
+	}
+
+	for i := range messages {
+		if err = c.handle(ctx, &messages[i]); err != nil {
+			return
+		}
+	}
+
+	return
+}
@@ -0,0 +1,111 @@
+// Copyright (c) 2015 Datacratic. All rights reserved.
+
+package streams
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/datacratic/goredis/redis"
+)
+
+func TestConsumer(t *testing.T) {
+	db, err := redis.NewTestDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer db.Close()
+
+	client := db.Dial()
+	defer client.Close()
+
+	reader := db.Dial()
+	defer reader.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	handled := make(chan string, 10)
+	failed := false
+
+	consumer := &Consumer{
+		Stream:            "events",
+		Group:             "workers",
+		Name:              "worker-1",
+		Block:             100 * time.Millisecond,
+		MinIdle:           time.Millisecond,
+		ClaimInterval:     10 * time.Millisecond,
+		MaximumDeliveries: 3,
+		DeadLetter:        "events-dead",
+		Client:            client,
+		Reader:            reader,
+		Handler: func(ctx context.Context, m *XMessage) error {
+			value := string(m.Get("value"))
+			if value == "poison" {
+				return fmt.Errorf("cannot handle poison")
+			}
+
+			// fail once to exercise redelivery
+			if value == "retry" && !failed {
+				failed = true
+				return fmt.Errorf("try again")
+			}
+
+			handled <- value
+			return nil
+		},
+	}
+
+	// create the group up front so that no entry is added before it exists
+	if _, err := client.Do("XGROUP", "CREATE", "events", "workers", "$", "MKSTREAM"); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error)
+	go func() {
+		done <- consumer.Run(ctx)
+	}()
+
+	for _, value := range []string{"first", "retry", "poison"} {
+		if _, err := client.Do("XADD", "events", "*", "value", value); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, expected := range []string{"first", "retry"} {
+		select {
+		case value := <-handled:
+			if value != expected {
+				t.Fatalf("unexpected value '%s' instead of '%s'", value, expected)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timeout waiting for '%s'", expected)
+		}
+	}
+
+	// the poison entry ends up in the dead letter stream
+	for i := 0; ; i++ {
+		n, err := client.Do("XLEN", "events-dead")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if n.(int64) == 1 {
+			break
+		}
+
+		if i == 100 {
+			t.Fatal("poison entry wasn't dead-lettered")
+		}
+
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
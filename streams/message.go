@@ -0,0 +1,178 @@
+// Copyright (c) 2015 Datacratic. All rights reserved.
+
+// Package streams implements consumer groups on top of Redis streams.
+package streams
+
+import (
+	"fmt"
+)
+
+// XMessage defines an entry of a stream.
+// Fields keeps the order of the field/value pairs while Values allows looking them up.
+type XMessage struct {
+	ID     string
+	Fields []string
+	Values map[string][]byte
+}
+
+// Get returns the value of the field.
+func (m *XMessage) Get(field string) []byte {
+	return m.Values[field]
+}
+
+// Args returns the field/value pairs as arguments for XADD.
+func (m *XMessage) Args() []interface{} {
+	result := make([]interface{}, 0, 2*len(m.Fields))
+	for _, field := range m.Fields {
+		result = append(result, field, m.Values[field])
+	}
+
+	return result
+}
+
+// ParseMessages decodes the reply of XRANGE, XREVRANGE or XCLAIM.
+// Deleted entries that are still pending are returned with nil Values.
+func ParseMessages(reply interface{}) (result []XMessage, err error) {
+	if reply == nil {
+		return
+	}
+
+	items, ok := reply.([]interface{})
+	if !ok {
+		err = fmt.Errorf("unexpected stream entries '%v'", reply)
+		return
+	}
+
+	result = make([]XMessage, 0, len(items))
+	for _, item := range items {
+		entry, ok := item.([]interface{})
+		if !ok || len(entry) != 2 {
+			err = fmt.Errorf("unexpected stream entry '%v'", item)
+			return
+		}
+
+		var m XMessage
+		if m.ID, err = text(entry[0]); err != nil {
+			return
+		}
+
+		if entry[1] != nil {
+			pairs, ok := entry[1].([]interface{})
+			if !ok || len(pairs)%2 != 0 {
+				err = fmt.Errorf("unexpected fields '%v' for stream entry %s", entry[1], m.ID)
+				return
+			}
+
+			m.Fields = make([]string, 0, len(pairs)/2)
+			m.Values = make(map[string][]byte, len(pairs)/2)
+			for i := 0; i < len(pairs); i += 2 {
+				var field string
+				if field, err = text(pairs[i]); err != nil {
+					return
+				}
+
+				value, _ := pairs[i+1].([]byte)
+				m.Fields = append(m.Fields, field)
+				m.Values[field] = value
+			}
+		}
+
+		result = append(result, m)
+	}
+
+	return
+}
+
+// ParseStreams decodes the reply of XREAD or XREADGROUP into the entries of each stream.
+func ParseStreams(reply interface{}) (result map[string][]XMessage, err error) {
+	if reply == nil {
+		return
+	}
+
+	result = make(map[string][]XMessage)
+
+	add := func(key, value interface{}) (err error) {
+		name, err := text(key)
+		if err != nil {
+			return
+		}
+
+		result[name], err = ParseMessages(value)
+		return
+	}
+
+	switch r := reply.(type) {
+	case []interface{}:
+		for _, item := range r {
+			stream, ok := item.([]interface{})
+			if !ok || len(stream) != 2 {
+				return nil, fmt.Errorf("unexpected stream '%v'", item)
+			}
+
+			if err = add(stream[0], stream[1]); err != nil {
+				return
+			}
+		}
+	case map[interface{}]interface{}:
+		for key, value := range r {
+			if err = add(key, value); err != nil {
+				return
+			}
+		}
+	default:
+		err = fmt.Errorf("unexpected streams '%v'", reply)
+	}
+
+	return
+}
+
+// Pending defines an entry of the extended form of XPENDING.
+type Pending struct {
+	ID         string
+	Consumer   string
+	Idle       int64
+	Deliveries int64
+}
+
+// ParsePending decodes the reply of XPENDING with a range.
+func ParsePending(reply interface{}) (result []Pending, err error) {
+	items, ok := reply.([]interface{})
+	if !ok && reply != nil {
+		err = fmt.Errorf("unexpected pending entries '%v'", reply)
+		return
+	}
+
+	for _, item := range items {
+		entry, ok := item.([]interface{})
+		if !ok || len(entry) != 4 {
+			err = fmt.Errorf("unexpected pending entry '%v'", item)
+			return
+		}
+
+		var p Pending
+		if p.ID, err = text(entry[0]); err != nil {
+			return
+		}
+
+		if p.Consumer, err = text(entry[1]); err != nil {
+			return
+		}
+
+		p.Idle, _ = entry[2].(int64)
+		p.Deliveries, _ = entry[3].(int64)
+		result = append(result, p)
+	}
+
+	return
+}
+
+func text(item interface{}) (string, error) {
+	switch item := item.(type) {
+	case []byte:
+		return string(item), nil
+	case string:
+		return item, nil
+	}
+
+	return "", fmt.Errorf("expecting a string instead of '%v'", item)
+}
@@ -0,0 +1,58 @@
+// Copyright (c) 2015 Datacratic. All rights reserved.
+
+package streams
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseStreams(t *testing.T) {
+	reply := []interface{}{
+		[]interface{}{
+			[]byte("events"),
+			[]interface{}{
+				[]interface{}{
+					[]byte("1-0"),
+					[]interface{}{[]byte("type"), []byte("click"), []byte("user"), []byte("42")},
+				},
+				[]interface{}{
+					[]byte("2-0"),
+					nil,
+				},
+			},
+		},
+	}
+
+	streams, err := ParseStreams(reply)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []XMessage{
+		{
+			ID:     "1-0",
+			Fields: []string{"type", "user"},
+			Values: map[string][]byte{"type": []byte("click"), "user": []byte("42")},
+		},
+		{
+			ID: "2-0",
+		},
+	}
+
+	if !reflect.DeepEqual(streams["events"], expected) {
+		t.Fatalf("unexpected entries '%+v' instead of '%+v'", streams["events"], expected)
+	}
+
+	if args := expected[0].Args(); !reflect.DeepEqual(args, []interface{}{"type", []byte("click"), "user", []byte("42")}) {
+		t.Fatal(args)
+	}
+
+	pending, err := ParsePending([]interface{}{
+		[]interface{}{[]byte("1-0"), []byte("worker"), int64(60000), int64(3)},
+	})
+
+	if err != nil || len(pending) != 1 || pending[0] != (Pending{"1-0", "worker", 60000, 3}) {
+		t.Fatal(err, pending)
+	}
+}